@@ -1,15 +1,14 @@
 package main
 
 import (
-	"github.com/ghthor/chordpad/input"
 	"github.com/ghthor/uinput"
 )
 
-var Chords = map[input.Chord]OutputEvent{
+// Chords is the layout that chordpad was first used with.
+var Chords = Layout{
 	17:  Func(FN_ESCAPE),
 	128: Letter(uinput.KEY_N),
 	132: Letter(uinput.KEY_V),
-	238: Letter(uinput.KEY_W),
 	224: Func(FN_ENTER),
 	68:  Func(FN_SPACE),
 	40:  Letter(uinput.KEY_Q),
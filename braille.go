@@ -0,0 +1,423 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// A BrailleCell is a six dot braille cell. Bit n-1 of Dots is dot n,
+// the same order as the Unicode braille patterns. Grade 1 cells are
+// translated as they are played. Grade 2 cells are translated a word
+// at a time, when the word is ended by any other key, so that
+// contractions can be read.
+type BrailleCell struct {
+	Dots  uint8
+	Grade int
+}
+
+// OutputTo types the letter or punctuation of the cell on its own,
+// as read in Grade 1.
+func (cell BrailleCell) OutputTo(sink KeySink) error {
+	r := brailleReader{}
+	return Text(r.cell(cell.Dots)).OutputTo(sink)
+}
+
+func (cell BrailleCell) String() string {
+	return string(rune(0x2800 + int(cell.Dots)))
+}
+
+// dots returns the cell with the given dot numbers, e.g. dots(1, 2)
+// for the letter b.
+func dots(numbers ...int) uint8 {
+	var cell uint8
+	for _, n := range numbers {
+		cell |= 1 << uint(n-1)
+	}
+	return cell
+}
+
+// parseDots converts dot numbers written as digits, e.g. "125",
+// into a cell.
+func parseDots(s string) (uint8, error) {
+	var cell uint8
+	for _, c := range s {
+		if c < '1' || c > '6' {
+			return 0, fmt.Errorf("invalid braille dots %q", s)
+		}
+		cell |= dots(int(c - '0'))
+	}
+	if cell == 0 {
+		return 0, fmt.Errorf("invalid braille dots %q", s)
+	}
+	return cell, nil
+}
+
+// formatDots is the inverse of parseDots.
+func formatDots(cell uint8) string {
+	var b strings.Builder
+	for n := 1; n <= 6; n++ {
+		if cell&dots(n) != 0 {
+			b.WriteString(strconv.Itoa(n))
+		}
+	}
+	return b.String()
+}
+
+var (
+	brailleCapital = dots(6)
+	brailleNumber  = dots(3, 4, 5, 6)
+	brailleLetter  = dots(5, 6)
+)
+
+var brailleLetters = map[uint8]rune{
+	dots(1): 'a', dots(1, 2): 'b', dots(1, 4): 'c', dots(1, 4, 5): 'd',
+	dots(1, 5): 'e', dots(1, 2, 4): 'f', dots(1, 2, 4, 5): 'g', dots(1, 2, 5): 'h',
+	dots(2, 4): 'i', dots(2, 4, 5): 'j', dots(1, 3): 'k', dots(1, 2, 3): 'l',
+	dots(1, 3, 4): 'm', dots(1, 3, 4, 5): 'n', dots(1, 3, 5): 'o', dots(1, 2, 3, 4): 'p',
+	dots(1, 2, 3, 4, 5): 'q', dots(1, 2, 3, 5): 'r', dots(2, 3, 4): 's', dots(2, 3, 4, 5): 't',
+	dots(1, 3, 6): 'u', dots(1, 2, 3, 6): 'v', dots(2, 4, 5, 6): 'w', dots(1, 3, 4, 6): 'x',
+	dots(1, 3, 4, 5, 6): 'y', dots(1, 3, 5, 6): 'z',
+}
+
+// brailleDigits are the letters a to j read after a number sign.
+var brailleDigits = map[rune]rune{
+	'a': '1', 'b': '2', 'c': '3', 'd': '4', 'e': '5',
+	'f': '6', 'g': '7', 'h': '8', 'i': '9', 'j': '0',
+}
+
+var braillePunctuation = map[uint8]string{
+	dots(2):       ",",
+	dots(2, 3):    ";",
+	dots(2, 5):    ":",
+	dots(2, 5, 6): ".",
+	dots(2, 3, 5): "!",
+	dots(2, 3, 6): "?",
+	dots(3, 5, 6): "\"",
+	dots(3):       "'",
+	dots(3, 6):    "-",
+}
+
+// brailleContractions are the Grade 2 signs that stand for a group
+// of letters anywhere in a word.
+var brailleContractions = map[uint8]string{
+	dots(1, 2, 3, 4, 6):    "and",
+	dots(1, 2, 3, 4, 5, 6): "for",
+	dots(1, 2, 3, 5, 6):    "of",
+	dots(2, 3, 4, 6):       "the",
+	dots(2, 3, 4, 5, 6):    "with",
+	dots(1, 6):             "ch",
+	dots(1, 2, 6):          "gh",
+	dots(1, 4, 6):          "sh",
+	dots(1, 4, 5, 6):       "th",
+	dots(1, 5, 6):          "wh",
+	dots(1, 2, 4, 6):       "ed",
+	dots(1, 2, 4, 5, 6):    "er",
+	dots(1, 2, 5, 6):       "ou",
+	dots(2, 4, 6):          "ow",
+	dots(3, 4):             "st",
+	dots(3, 4, 5):          "ar",
+	dots(3, 4, 6):          "ing",
+}
+
+// brailleWordsigns are the Grade 2 words written as a single sign
+// that stands alone.
+var brailleWordsigns = map[uint8]string{
+	dots(1, 2): "but", dots(1, 4): "can", dots(1, 4, 5): "do", dots(1, 5): "every",
+	dots(1, 2, 4): "from", dots(1, 2, 4, 5): "go", dots(1, 2, 5): "have", dots(2, 4, 5): "just",
+	dots(1, 3): "knowledge", dots(1, 2, 3): "like", dots(1, 3, 4): "more", dots(1, 3, 4, 5): "not",
+	dots(1, 2, 3, 4): "people", dots(1, 2, 3, 4, 5): "quite", dots(1, 2, 3, 5): "rather",
+	dots(2, 3, 4): "so", dots(2, 3, 4, 5): "that", dots(1, 3, 6): "us", dots(1, 2, 3, 6): "very",
+	dots(2, 4, 5, 6): "will", dots(1, 3, 4, 6): "it", dots(1, 3, 4, 5, 6): "you", dots(1, 3, 5, 6): "as",
+
+	dots(1, 6): "child", dots(1, 4, 6): "shall", dots(1, 4, 5, 6): "this",
+	dots(1, 5, 6): "which", dots(1, 2, 5, 6): "out", dots(3, 4): "still",
+}
+
+// A brailleReader translates the cells of a word into text. It keeps
+// the capital and number signs that change the cells after them.
+type brailleReader struct {
+	grade int
+
+	capital  bool
+	capsWord bool
+	number   bool
+}
+
+// cell returns the text of the next cell of a word.
+func (r *brailleReader) cell(cell uint8) string {
+	switch cell {
+	case brailleCapital:
+		// A second capital sign capitalizes the rest of the word
+		r.capsWord = r.capital
+		r.capital = true
+		return ""
+	case brailleNumber:
+		r.number = true
+		return ""
+	case brailleLetter:
+		r.number = false
+		return ""
+	default:
+	}
+
+	if letter, isLetter := brailleLetters[cell]; isLetter {
+		if digit, isDigit := brailleDigits[letter]; isDigit && r.number {
+			return string(digit)
+		}
+		r.number = false
+		return r.capitalize(string(letter))
+	}
+	r.number = false
+
+	if r.grade >= 2 {
+		if text, isContraction := brailleContractions[cell]; isContraction {
+			return r.capitalize(text)
+		}
+	}
+
+	if text, isPunctuation := braillePunctuation[cell]; isPunctuation {
+		return text
+	}
+	return ""
+}
+
+func (r *brailleReader) capitalize(text string) string {
+	switch {
+	case r.capsWord:
+		return strings.ToUpper(text)
+	case r.capital:
+		r.capital = false
+		return strings.ToUpper(text[:1]) + text[1:]
+	default:
+	}
+	return text
+}
+
+// endWord forgets the signs that only last until the end of a word.
+func (r *brailleReader) endWord() {
+	*r = brailleReader{grade: r.grade}
+}
+
+// word translates the cells of a whole Grade 2 word. A word of one
+// sign, apart from capital signs and punctuation after it, is read as
+// a wordsign.
+func (r *brailleReader) word(cells []uint8) string {
+	defer r.endWord()
+
+	start := 0
+	for start < len(cells) && cells[start] == brailleCapital {
+		start++
+	}
+	end := len(cells)
+	for end > start {
+		if _, isPunctuation := braillePunctuation[cells[end-1]]; !isPunctuation {
+			break
+		}
+		end--
+	}
+
+	var b strings.Builder
+	if end-start == 1 {
+		if word, isWordsign := brailleWordsigns[cells[start]]; isWordsign {
+			for _, cell := range cells[:start] {
+				r.cell(cell)
+			}
+			b.WriteString(r.capitalize(word))
+			cells = cells[end:]
+		}
+	}
+
+	for _, cell := range cells {
+		b.WriteString(r.cell(cell))
+	}
+	return b.String()
+}
+
+// DefaultBrailleDots are the chord keys used for dots 1 to 6. Dots
+// 1 and 4 are the triggers, 2 and 5 the bumpers and 3 and 6 the S
+// zones of the pads, so that every cell is pressed at once, with the
+// left hand playing dots 1 to 3 like a Perkins brailler.
+var DefaultBrailleDots = [6]input.Chord{
+	BTN_TL1, BTN_TL0, 1 << PAD_S,
+	BTN_TR1, BTN_TR0, 1 << (PAD_S + 4),
+}
+
+// DefaultBrailleTimeout is how long a Grade 2 word waits for its
+// next cell before it is typed.
+const DefaultBrailleTimeout = 2 * time.Second
+
+// NewBrailleLayout binds every combination of the six chord keys,
+// one for each dot, to the braille cell with those dots. The inner
+// zones of the pads are space, the outer zone of the left pad is
+// backspace and of the right pad is enter, unless they are dots.
+func NewBrailleLayout(keys [6]input.Chord, grade int) Layout {
+	layout := Layout{
+		1 << PAD_E:       Func(FN_SPACE),
+		1 << (PAD_W + 4): Func(FN_SPACE),
+		1 << PAD_W:       Func(FN_BACKSPACE),
+		1 << (PAD_E + 4): Func(FN_ENTER),
+	}
+
+	for cell := uint8(1); cell < 1<<6; cell++ {
+		var chord input.Chord
+		for i, key := range keys {
+			if cell&(1<<uint(i)) != 0 {
+				chord |= key
+			}
+		}
+		layout[chord] = BrailleCell{cell, grade}
+	}
+	return layout
+}
+
+// A brailleWriter translates the braille cells played into text
+// for the filters after it. Grade 1 cells are typed as they are
+// played. Grade 2 cells are held back until their word is ended by
+// any other key, such as space, or by the timeout passing without
+// another cell. A backspace deletes the last of them.
+type brailleWriter struct {
+	keymap  Keymap
+	timeout time.Duration
+	reader  brailleReader
+
+	// The Grade 2 cells of the word being played, the chord of the
+	// last one and when the word times out.
+	word     []uint8
+	chord    input.Chord
+	deadline time.Time
+}
+
+func (w *brailleWriter) filter(e input.Event) []input.Event {
+	switch e := e.(type) {
+	case Played:
+		return append(w.endWord(e.Time), e)
+	case input.ChordCommitted:
+		return w.commit(e)
+	default:
+	}
+	return []input.Event{e}
+}
+
+func (w *brailleWriter) commit(e input.ChordCommitted) []input.Event {
+	key, isBound := w.keymap.Lookup(e.Chord)
+	if !isBound {
+		return []input.Event{e}
+	}
+
+	cell, isCell := key.(BrailleCell)
+	switch {
+	case isCell && cell.Grade < 2:
+		// The capital and number signs carry over to the next cell
+		var events []input.Event
+		if len(w.word) > 0 {
+			events = w.endWord(e.Time)
+		}
+
+		w.reader.grade = cell.Grade
+		return append(events, w.typed(e.Chord, w.reader.cell(cell.Dots), e.Time)...)
+
+	case isCell:
+		w.reader.grade = cell.Grade
+		w.word = append(w.word, cell.Dots)
+		w.chord = e.Chord
+		w.deadline = e.Time.Add(w.timeout)
+		return nil
+
+	case key == Func(FN_BACKSPACE) && len(w.word) > 0:
+		w.word = w.word[:len(w.word)-1]
+		return nil
+
+	default:
+	}
+	return append(w.endWord(e.Time), e)
+}
+
+// endWord types the Grade 2 word being played, if any, and forgets
+// the signs that only last until the end of a word.
+func (w *brailleWriter) endWord(at time.Time) []input.Event {
+	cells := w.word
+	w.word = nil
+
+	if len(cells) == 0 {
+		w.reader.endWord()
+		return nil
+	}
+	return w.typed(w.chord, w.reader.word(cells), at)
+}
+
+func (w *brailleWriter) typed(chord input.Chord, text string, at time.Time) []input.Event {
+	if text == "" {
+		return nil
+	}
+	return []input.Event{Played{Chord: chord, Key: Text(text), Time: at}}
+}
+
+// expire types the word being played once its timeout has passed.
+// A timeout of 0 waits for the word to be ended by another key.
+func (w *brailleWriter) expire(now time.Time) []input.Event {
+	if _, isDue := w.nextDue(); !isDue || now.Before(w.deadline) {
+		return nil
+	}
+	return w.endWord(w.deadline)
+}
+
+func (w *brailleWriter) nextDue() (time.Time, bool) {
+	return w.deadline, len(w.word) > 0 && w.timeout > 0
+}
+
+// end types the word being played when the events end.
+func (w *brailleWriter) end(now time.Time) []input.Event {
+	return w.endWord(now)
+}
+
+// brailleLayout writes a layout file that binds six chosen chord keys
+// to braille dots.
+func brailleLayout(args []string) error {
+	flags := flag.NewFlagSet("braille-layout", flag.ExitOnError)
+	outputPath := flags.String("o", "", "file to write the layout into, stdout if empty")
+	grade := flags.Int("grade", 1, "braille grade, 1 or 2 for contractions")
+
+	var defaults []string
+	for _, key := range DefaultBrailleDots {
+		defaults = append(defaults, strconv.Itoa(int(key)))
+	}
+	dotKeys := flags.String("dots", strings.Join(defaults, ","),
+		"comma separated chord keys of dots 1 to 6")
+	flags.Parse(args)
+
+	if *grade != 1 && *grade != 2 {
+		return fmt.Errorf("unknown braille grade %d", *grade)
+	}
+
+	var keys [6]input.Chord
+	chords := strings.Split(*dotKeys, ",")
+	if len(chords) != len(keys) {
+		return fmt.Errorf("-dots needs %d chord keys, got %d", len(keys), len(chords))
+	}
+	for i, chord := range chords {
+		c, err := strconv.ParseUint(strings.TrimSpace(chord), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid chord key %q", chord)
+		}
+		keys[i] = input.Chord(c)
+	}
+
+	var w io.Writer = os.Stdout
+	if *outputPath != "" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return WriteLayoutJSON(w, NewBrailleLayout(keys, *grade))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+func TestBraille(t *testing.T) {
+	// Dot 1 is LT, dot 2 is TL and dot 6 is the S zone of the right
+	// pad. The E zone of the left pad is space and its W zone is
+	// backspace.
+	cases := []struct {
+		name    string
+		layout  string
+		timeout time.Duration
+		script  string
+		want    string
+	}{
+		{"grade 1 types each cell",
+			"braille", 0, "press LT, release, press LT, press TL, release", "A B"},
+		{"a capital sign capitalizes the next cell",
+			"braille", 0, "right S, release, press LT, release, press LT, release", "RIGHTSHIFT A A"},
+		{"grade 2 types a word when it ends",
+			"braille2", 0, "press LT, release, press LT, press TL, release, left E, left up", "A B SPACE"},
+		{"a cell on its own is a wordsign",
+			"braille2", 0, "press LT, press TL, release, left E, left up", "B U T SPACE"},
+		{"backspace deletes the last cell of a word",
+			"braille2", 0, "press LT, press TL, release, press LT, release, left W, left up, left E, left up",
+			"B U T SPACE"},
+		{"a word is typed after the timeout",
+			"braille2", time.Second, "press LT, press TL, release, wait 2s, press LT, release, left E, left up",
+			"B U T A SPACE"},
+		{"a word waits for a space without a timeout",
+			"braille2", 0, "press LT, press TL, release, wait 2s, press LT, release, left E, left up",
+			"B A SPACE"},
+		{"a word is typed when the events end",
+			"braille2", 0, "press LT, press TL, release", "B U T"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := typed(t, c.script, Layouts[c.layout], input.Timing{}, Player{BrailleTimeout: c.timeout})
+			if got != c.want {
+				t.Errorf("%q typed %q, want %q", c.script, got, c.want)
+			}
+		})
+	}
+}
+
+func TestDefaultBrailleDotsArePressedTogether(t *testing.T) {
+	var pads [2]int
+	for _, key := range DefaultBrailleDots {
+		for pad := range pads {
+			if key&(PAD_ALL<<uint(4*pad)) != 0 {
+				pads[pad]++
+			}
+		}
+	}
+
+	// A thumb can only touch one zone of a pad at a time
+	if pads[0] > 1 || pads[1] > 1 {
+		t.Errorf("dots use %d zones of the left pad and %d of the right", pads[0], pads[1])
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	evdev "github.com/ghthor/golang-evdev"
+)
+
+// A command is a subcommand of the chordpad executable.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "link an evdev device to a uinput virtual keyboard (default)", run},
+		{"list-devices", "list evdev devices and whether they are supported", listDevices},
+		{"check-layout", "report duplicate, unreachable and missing bindings", checkLayout},
+		{"optimize", "generate a layout from the character frequency of a corpus", optimize},
+		{"braille-layout", "generate a six dot braille layout for chosen chord keys", brailleLayout},
+		{"monitor", "draw the pads and the chord being built in the terminal", monitor},
+		{"record", "record the input events from an evdev device", record},
+		{"replay", "play a recording through the chord pipeline", replay},
+		{"simulate", "play a scripted scenario through the chord pipeline", simulate},
+		{"stats", "report chord usage and unbound chord statistics", statsCommand},
+		{"tutor", "practice typing lines of text and track accuracy and speed", tutorCommand},
+		{"ctl", "send a command to the control socket of a running chordpad", ctl},
+		{"help", "print this help", help},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chordpad <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	w.Flush()
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Use \"chordpad <command> -h\" for the flags of a command.")
+}
+
+func help(args []string) error {
+	usage()
+	return nil
+}
+
+// runCommand dispatches to the subcommand named by the first
+// argument. The run command is used when no arguments are given.
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		return run(nil)
+	}
+
+	name := args[0]
+	if name == "-h" || name == "-help" || name == "--help" {
+		name = "help"
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(args[1:])
+		}
+	}
+
+	usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// steamControllerAxes are the absolute axes a device must report
+// to be used as a steamController.
+var steamControllerAxes = []int{
+	evdev.ABS_HAT0X, evdev.ABS_HAT0Y,
+	evdev.ABS_RX, evdev.ABS_RY,
+	evdev.ABS_Z, evdev.ABS_RZ,
+}
+
+// isSupportedDevice reports whether an evdev device has the inputs
+// needed to produce chords.
+func isSupportedDevice(dev *evdev.InputDevice) bool {
+	axes := make(map[int]bool)
+	for capType, codes := range dev.Capabilities {
+		if capType.Type != evdev.EV_ABS {
+			continue
+		}
+
+		for _, code := range codes {
+			axes[code.Code] = true
+		}
+	}
+
+	for _, axis := range steamControllerAxes {
+		if !axes[axis] {
+			return false
+		}
+	}
+	return true
+}
+
+func listDevices(args []string) error {
+	devices, err := evdev.ListInputDevices("/dev/input/event*")
+	if err != nil {
+		return err
+	}
+
+	if len(devices) == 0 {
+		return ErrNoValidInputDevices
+	}
+
+	for _, dev := range devices {
+		support := "unsupported"
+		if isSupportedDevice(dev) {
+			support = "supported controller"
+		}
+
+		fmt.Printf("%s  %s (%s)\n", dev.Fn, dev.Name, support)
+		fmt.Printf("  bus 0x%04x, vendor 0x%04x, product 0x%04x, version 0x%04x\n",
+			dev.Bustype, dev.Vendor, dev.Product, dev.Version)
+
+		var capTypes []evdev.CapabilityType
+		for capType := range dev.Capabilities {
+			capTypes = append(capTypes, capType)
+		}
+		sort.Slice(capTypes, func(i, j int) bool {
+			return capTypes[i].Type < capTypes[j].Type
+		})
+
+		for _, capType := range capTypes {
+			var names []string
+			for _, code := range dev.Capabilities[capType] {
+				names = append(names, code.Name)
+			}
+			fmt.Printf("  %s: %s\n", capType.Name, strings.Join(names, " "))
+		}
+
+		dev.File.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// A Config holds the settings used to run chordpad. It is read
+// from a JSON file and any flags given on the command line take
+// precedence over the values in the file.
+type Config struct {
+	// Path to the evdev device used to produce chords. A device
+	// will be auto selected if empty.
+	Device string `json:"device"`
+
+	// Path to the uinput device file used to create the virtual
+	// keyboard.
+	Uinput string `json:"uinput"`
+
+	// Minimum time between key events sent to the virtual keyboard.
+	KeyInterval Duration `json:"key_interval"`
+
+	// Windows in which presses and releases of different keys are
+	// counted as simultaneous, see input.Timing. Both are off by
+	// default, which commits a chord on the release that played it.
+	// A press_window of "30ms" and release_grace of "15ms" help a
+	// thumb that lands late or lifts early.
+	PressWindow  Duration `json:"press_window"`
+	ReleaseGrace Duration `json:"release_grace"`
+
+	// Let the next chord start while keys of the last one are still
+	// held down, see input.Timing.
+	Rollover bool `json:"rollover"`
+
+	// How a chord held down repeats, one of off, output or hold, and
+	// the delay before and interval between repeats. The repeat rate
+	// of the input device is used for a delay or interval of 0.
+	// Chords don't repeat by default. Only a chord that is held
+	// unchanged for the delay repeats, so the delay should be longer
+	// than it takes to build a chord. A hold only repeats if the
+	// desktop's own key repeat is on, at the desktop's rate.
+	Repeat         string   `json:"repeat"`
+	RepeatDelay    Duration `json:"repeat_delay"`
+	RepeatInterval Duration `json:"repeat_interval"`
+
+	// How long a TapHold chord is held before it plays its Hold
+	// instead of its Tap. TapHold chords only tap if it is 0.
+	HoldThreshold Duration `json:"hold_threshold"`
+
+	// How long to wait for the next chord of a sequence started by
+	// a leader chord.
+	SequenceTimeout Duration `json:"sequence_timeout"`
+
+	// How long a Grade 2 braille word waits for its next cell before
+	// it is typed. Words are only typed once another key, such as
+	// space, ends them if it is 0.
+	BrailleTimeout Duration `json:"braille_timeout"`
+
+	// Name of the layout used to bind chords to output.
+	Layout string `json:"layout"`
+
+	// Path to the file chord usage statistics are saved in. No
+	// statistics are saved if empty.
+	Stats string `json:"stats"`
+
+	// Loopback address to serve metrics on, e.g. 127.0.0.1:9273.
+	// Metrics aren't served if empty.
+	Metrics string `json:"metrics"`
+
+	// Path of the unix socket that accepts control commands. There
+	// is no control socket if empty.
+	Control string `json:"control"`
+}
+
+// DefaultConfig is used for any setting that isn't provided by a
+// config file or a flag.
+var DefaultConfig = Config{
+	Uinput:      "/dev/uinput",
+	KeyInterval: Duration{DefaultKeyInterval},
+	Layout:      DefaultLayout,
+	Stats:       defaultDataPath("stats.json"),
+	Control:     defaultRuntimePath("control.sock"),
+	Repeat:      RepeatOff,
+
+	HoldThreshold:   Duration{DefaultHoldThreshold},
+	SequenceTimeout: Duration{DefaultSequenceTimeout},
+	BrailleTimeout:  Duration{DefaultBrailleTimeout},
+}
+
+// Timing returns the windows used to combine presses and releases.
+func (c Config) Timing() input.Timing {
+	return input.Timing{
+		PressWindow:  c.PressWindow.Duration,
+		ReleaseGrace: c.ReleaseGrace.Duration,
+		Rollover:     c.Rollover,
+	}
+}
+
+// AutoRepeat returns how held chords repeat. The delay and period
+// are those of the input device, used if the config doesn't set
+// them, and may be 0 if the device doesn't repeat keys.
+func (c Config) AutoRepeat(delay, period time.Duration) Repeat {
+	repeat := Repeat{c.Repeat, c.RepeatDelay.Duration, c.RepeatInterval.Duration}
+	if repeat.Delay == 0 {
+		repeat.Delay = delay
+	}
+	if repeat.Interval == 0 {
+		repeat.Interval = period
+	}
+
+	if repeat.Delay == 0 {
+		repeat.Delay = DefaultRepeatDelay
+	}
+	if repeat.Interval == 0 {
+		repeat.Interval = DefaultRepeatInterval
+	}
+	return repeat
+}
+
+const (
+	pressWindowUsage    = "keys pressed within this time of the start of a chord are part of it, e.g. 30ms"
+	releaseGraceUsage   = "time after a release that a chord can still be added to before it is played, e.g. 15ms"
+	rolloverUsage       = "start the next chord while keys of the last one are still held down"
+	repeatUsage         = "how a held chord repeats: off, output to play it again, or hold to hold its keys down"
+	repeatDelayUsage    = "time a chord is held before it repeats"
+	repeatIntervalUsage = "time between repeats of a held chord"
+	holdThresholdUsage  = "time a tap/hold chord is held before it holds a modifier instead of tapping"
+	sequenceUsage       = "time to wait for the next chord of a sequence started by a leader"
+	brailleTimeoutUsage = "time a grade 2 braille word waits for its next cell before it is typed, 0 to wait for a space"
+)
+
+// A Duration is a time.Duration that is encoded in JSON using the
+// format accepted by time.ParseDuration, e.g. "8ms".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+// LoadConfig reads a config file on top of the DefaultConfig.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig
+
+	f, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&config)
+	return config, err
+}
+
+// configFlags binds the settings of a Config to a FlagSet. The
+// flags that were set are applied on top of a config file with
+// Apply after the FlagSet has been parsed.
+type configFlags struct {
+	flags *flag.FlagSet
+	path  *string
+
+	values Config
+}
+
+func newConfigFlags(flags *flag.FlagSet) *configFlags {
+	c := newPlaybackFlags(flags)
+	flags.StringVar(&c.values.Device, "device", c.values.Device,
+		"evdev device used to produce chords, auto selected if empty")
+	flags.StringVar(&c.values.Uinput, "uinput", c.values.Uinput,
+		"uinput device file used to create the virtual keyboard")
+	flags.DurationVar(&c.values.KeyInterval.Duration, "key-interval", c.values.KeyInterval.Duration,
+		"minimum time between key events sent to the virtual keyboard")
+	flags.StringVar(&c.values.Stats, "stats", c.values.Stats,
+		"file chord usage statistics are saved in, disabled if empty")
+	flags.StringVar(&c.values.Metrics, "metrics", c.values.Metrics,
+		"localhost address to serve metrics on, e.g. 127.0.0.1:9273")
+	flags.StringVar(&c.values.Control, "control", c.values.Control,
+		"unix socket that accepts control commands, disabled if empty")
+	return c
+}
+
+// newPlaybackFlags binds only the settings of a Config that change
+// how chords are played, for the commands that play chords without
+// linking a device.
+func newPlaybackFlags(flags *flag.FlagSet) *configFlags {
+	c := &configFlags{flags: flags, values: DefaultConfig}
+	c.path = flags.String("config", "", "path to a JSON config file")
+	flags.DurationVar(&c.values.PressWindow.Duration, "press-window", c.values.PressWindow.Duration,
+		pressWindowUsage)
+	flags.DurationVar(&c.values.ReleaseGrace.Duration, "release-grace", c.values.ReleaseGrace.Duration,
+		releaseGraceUsage)
+	flags.BoolVar(&c.values.Rollover, "rollover", c.values.Rollover, rolloverUsage)
+	flags.StringVar(&c.values.Repeat, "repeat", c.values.Repeat, repeatUsage)
+	flags.DurationVar(&c.values.RepeatDelay.Duration, "repeat-delay", c.values.RepeatDelay.Duration,
+		repeatDelayUsage+", the input device's if 0")
+	flags.DurationVar(&c.values.RepeatInterval.Duration, "repeat-interval", c.values.RepeatInterval.Duration,
+		repeatIntervalUsage+", the input device's if 0")
+	flags.DurationVar(&c.values.HoldThreshold.Duration, "hold-threshold", c.values.HoldThreshold.Duration,
+		holdThresholdUsage)
+	flags.DurationVar(&c.values.SequenceTimeout.Duration, "sequence-timeout", c.values.SequenceTimeout.Duration,
+		sequenceUsage)
+	flags.DurationVar(&c.values.BrailleTimeout.Duration, "braille-timeout", c.values.BrailleTimeout.Duration,
+		brailleTimeoutUsage)
+	flags.StringVar(&c.values.Layout, "layout", c.values.Layout, layoutUsage())
+	return c
+}
+
+// Config returns the config file, if one was given, with the flags
+// that were set applied to it.
+func (c *configFlags) Config() (Config, error) {
+	if *c.path == "" {
+		return c.values, validRepeatMode(c.values.Repeat)
+	}
+
+	config, err := LoadConfig(*c.path)
+	if err != nil {
+		return config, err
+	}
+
+	c.flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "device":
+			config.Device = c.values.Device
+		case "uinput":
+			config.Uinput = c.values.Uinput
+		case "key-interval":
+			config.KeyInterval = c.values.KeyInterval
+		case "press-window":
+			config.PressWindow = c.values.PressWindow
+		case "release-grace":
+			config.ReleaseGrace = c.values.ReleaseGrace
+		case "rollover":
+			config.Rollover = c.values.Rollover
+		case "repeat":
+			config.Repeat = c.values.Repeat
+		case "repeat-delay":
+			config.RepeatDelay = c.values.RepeatDelay
+		case "repeat-interval":
+			config.RepeatInterval = c.values.RepeatInterval
+		case "hold-threshold":
+			config.HoldThreshold = c.values.HoldThreshold
+		case "sequence-timeout":
+			config.SequenceTimeout = c.values.SequenceTimeout
+		case "braille-timeout":
+			config.BrailleTimeout = c.values.BrailleTimeout
+		case "layout":
+			config.Layout = c.values.Layout
+		case "stats":
+			config.Stats = c.values.Stats
+		case "metrics":
+			config.Metrics = c.values.Metrics
+		case "control":
+			config.Control = c.values.Control
+		default:
+		}
+	})
+
+	return config, validRepeatMode(config.Repeat)
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// controlTimeout is how long a control connection may take to send
+// its command and read the reply.
+const controlTimeout = 5 * time.Second
+
+// defaultRuntimePath returns the path of a file in the runtime
+// directory of the user, or a directory of their own in the temporary
+// directory if they have none. privateDir checks that it is theirs
+// before it is used.
+func defaultRuntimePath(name string) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("chordpad-%d", os.Getuid()), name)
+	}
+	return filepath.Join(dir, "chordpad", name)
+}
+
+// A Control is the state of a running chordpad that can be changed
+// through its control socket. It is the Keymap chords are played
+// with so the layout can be switched while running.
+type Control struct {
+	// Reload returns the config to apply when a reload is requested.
+	Reload func() (Config, error)
+
+	mu         sync.RWMutex
+	config     Config
+	paused     bool
+	layout     Layout
+	layoutName string
+	devices    map[string]string
+	model      input.Model
+}
+
+// NewControl creates a Control for a config that is running with
+// the layout it names.
+func NewControl(config Config, layout Layout) *Control {
+	return &Control{
+		config:     config,
+		layout:     layout,
+		layoutName: config.Layout,
+		devices:    make(map[string]string),
+	}
+}
+
+// Config returns the config that is running, including the settings
+// of a reload that apply once the input device is linked again.
+func (c *Control) Config() Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+func (c *Control) Lookup(chord input.Chord) (OutputEvent, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.layout.Lookup(chord)
+}
+
+// SetLayout switches to the layout with the given name.
+func (c *Control) SetLayout(name string) error {
+	layout, err := LookupLayout(name)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.layout, c.layoutName = layout, name
+	c.mu.Unlock()
+
+	log.Println("switched to layout", name)
+	return nil
+}
+
+func (c *Control) LayoutName() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.layoutName
+}
+
+// restartSettings are the settings of a Config that can't be changed
+// by a reload because they are only used when chordpad starts.
+var restartSettings = map[string]bool{
+	"uinput":  true,
+	"stats":   true,
+	"metrics": true,
+	"control": true,
+}
+
+// changedSettings returns the names of the settings that differ
+// between two configs, as they are written in a config file.
+func changedSettings(a, b Config) []string {
+	var names []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			names = append(names, va.Type().Field(i).Tag.Get("json"))
+		}
+	}
+	return names
+}
+
+// ReloadConfig rereads the config and switches to its layout. The
+// other settings that changed apply when the input device is next
+// linked, except for those that need chordpad to be restarted, which
+// are logged and keep their running values.
+func (c *Control) ReloadConfig() error {
+	if c.Reload == nil {
+		return errors.New("reload isn't supported")
+	}
+
+	config, err := c.Reload()
+	if err != nil {
+		return err
+	}
+
+	if err := c.SetLayout(config.Layout); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	running := c.config
+	c.config = config
+	c.config.Uinput, c.config.Stats = running.Uinput, running.Stats
+	c.config.Metrics, c.config.Control = running.Metrics, running.Control
+	c.mu.Unlock()
+
+	var relink, restart []string
+	for _, name := range changedSettings(running, config) {
+		switch {
+		case name == "layout":
+		case restartSettings[name]:
+			restart = append(restart, name)
+		default:
+			relink = append(relink, name)
+		}
+	}
+
+	if len(relink) > 0 {
+		log.Printf("reload: %s will apply when the input device is next linked", strings.Join(relink, ", "))
+	}
+	if len(restart) > 0 {
+		log.Printf("reload: %s won't apply until chordpad is restarted", strings.Join(restart, ", "))
+	}
+	return nil
+}
+
+// Pause stops or resumes playing chords. The pads are still read
+// while paused so the controller can be used for something else.
+func (c *Control) Pause(paused bool) {
+	c.mu.Lock()
+	c.paused = paused
+	c.mu.Unlock()
+
+	if paused {
+		log.Println("output paused")
+	} else {
+		log.Println("output resumed")
+	}
+}
+
+func (c *Control) Paused() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.paused
+}
+
+// Attach records an input device as being used to produce chords.
+func (c *Control) Attach(path, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices[path] = name
+}
+
+func (c *Control) Detach(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.devices, path)
+}
+
+// Model returns the most recent model change.
+func (c *Control) Model() input.Model {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+// Tap keeps the model up to date with each event and removes the
+// chord events while paused, so chords are neither played nor held
+// to repeat. A chord that is being built when output is paused is
+// cancelled. It should be the first stage so that later stages don't
+// see chords that aren't played.
+func (c *Control) Tap() Stage {
+	return func(ctx context.Context, events <-chan input.Event) <-chan input.Event {
+		output := make(chan input.Event)
+
+		go func() {
+			defer close(output)
+
+			// The chord being built as seen by the later stages
+			var building input.Chord
+
+			for e := range events {
+				c.mu.Lock()
+				c.model = c.model.Apply(e)
+				paused := c.paused
+				c.mu.Unlock()
+
+				switch chord := e.(type) {
+				case input.KeyDown, input.KeyUp:
+				case input.ChordCancelled:
+					if building == 0 {
+						continue
+					}
+					e, building = input.ChordCancelled{Chord: building, Time: chord.Time}, 0
+
+				default:
+					if paused {
+						if building == 0 {
+							continue
+						}
+						e, building = input.ChordCancelled{Chord: building, Time: e.When()}, 0
+						break
+					}
+
+					switch chord := chord.(type) {
+					case input.ChordStarted:
+						building = chord.Chord
+					case input.ChordChanged:
+						// The chord was started while paused
+						if building == 0 {
+							e = input.ChordStarted{Chord: chord.To, Time: chord.Time}
+						}
+						building = chord.To
+					case input.ChordCommitted:
+						// The chord was built while paused, so it was never started
+						if building == 0 {
+							continue
+						}
+						building = 0
+					default:
+					}
+				}
+
+				select {
+				case output <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		return output
+	}
+}
+
+// A controlCommand is a command accepted by the control socket.
+type controlCommand struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *Control, w io.Writer, args []string) error
+}
+
+var controlCommands []controlCommand
+
+func init() {
+	controlCommands = []controlCommand{
+		{"pause", "", "stop playing chords", func(c *Control, w io.Writer, args []string) error {
+			c.Pause(true)
+			return nil
+		}},
+		{"resume", "", "start playing chords again", func(c *Control, w io.Writer, args []string) error {
+			c.Pause(false)
+			return nil
+		}},
+		{"toggle", "", "pause if playing chords, otherwise resume", func(c *Control, w io.Writer, args []string) error {
+			c.Pause(!c.Paused())
+			return nil
+		}},
+		{"status", "", "print whether chords are paused and the layout", controlStatus},
+		{"layout", "[name]", "switch to a layout or print the current layout", controlLayout},
+		{"reload", "", "reread the config file, its layout applies now and the rest on the next link", controlReload},
+		{"devices", "", "list the input devices in use", controlDevices},
+		{"model", "", "print the current chord model as JSON", controlModel},
+		{"help", "", "list the commands", controlHelp},
+	}
+}
+
+func controlStatus(c *Control, w io.Writer, args []string) error {
+	state := "playing"
+	if c.Paused() {
+		state = "paused"
+	}
+	fmt.Fprintf(w, "%s, layout %s\n", state, c.LayoutName())
+	return nil
+}
+
+func controlLayout(c *Control, w io.Writer, args []string) error {
+	switch len(args) {
+	case 0:
+		fmt.Fprintln(w, c.LayoutName())
+		return nil
+	case 1:
+		return c.SetLayout(args[0])
+	default:
+	}
+	return errors.New("layout takes a single layout name")
+}
+
+func controlReload(c *Control, w io.Writer, args []string) error {
+	return c.ReloadConfig()
+}
+
+func controlDevices(c *Control, w io.Writer, args []string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var paths []string
+	for path := range c.devices {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		fmt.Fprintf(w, "%s  %s\n", path, c.devices[path])
+	}
+	return nil
+}
+
+func controlModel(c *Control, w io.Writer, args []string) error {
+	b, err := json.MarshalIndent(c.Model(), "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+func controlHelp(c *Control, w io.Writer, args []string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, cmd := range controlCommands {
+		fmt.Fprintf(tw, "%s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	return tw.Flush()
+}
+
+// Exec runs a control command, a line of words separated by spaces,
+// and writes its reply to w.
+func (c *Control) Exec(line string, w io.Writer) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+
+	for _, cmd := range controlCommands {
+		if cmd.name == args[0] {
+			return cmd.run(c, w, args[1:])
+		}
+	}
+	return fmt.Errorf("unknown command %q, try help", args[0])
+}
+
+func (c *Control) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Println("control:", err)
+		return
+	}
+
+	w := bufio.NewWriter(conn)
+	if err := c.Exec(line, w); err != nil {
+		fmt.Fprintln(w, "error:", err)
+	}
+	w.Flush()
+}
+
+// privateDir creates a directory that only the user can use, or
+// checks that an existing one is a directory of theirs that no one
+// else can write to, e.g. one in a shared /tmp that they created.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	stat, isUnix := info.Sys().(*syscall.Stat_t)
+	switch {
+	case !info.IsDir():
+		return fmt.Errorf("%s isn't a directory", dir)
+	case !isUnix || int(stat.Uid) != os.Getuid():
+		return fmt.Errorf("%s isn't owned by the user running chordpad", dir)
+	case info.Mode().Perm()&0022 != 0:
+		return fmt.Errorf("%s can be written to by other users", dir)
+	default:
+	}
+	return nil
+}
+
+// ListenAndServe accepts commands on a unix socket at path that only
+// the user running chordpad can connect to. A socket left behind by
+// a chordpad that has exited is replaced. The returned listener
+// stops serving and removes the socket when closed.
+func (c *Control) ListenAndServe(path string) (io.Closer, error) {
+	if err := privateDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another chordpad", path)
+	}
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	log.Println("accepting control commands on", path)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go c.serveConn(conn)
+		}
+	}()
+
+	return l, nil
+}
+
+// ctl sends a command to the control socket of a running chordpad
+// and prints the reply.
+func ctl(args []string) error {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socketPath := flags.String("control", DefaultConfig.Control, "control socket of the running chordpad")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: chordpad ctl [flags] <command> [args]")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "commands:")
+		controlHelp(nil, os.Stderr, nil)
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("ctl requires a command")
+	}
+
+	conn, err := net.DialTimeout("unix", *socketPath, controlTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	_, err = fmt.Fprintln(conn, strings.Join(flags.Args(), " "))
+	if err != nil {
+		return err
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+
+	if msg := string(reply); strings.HasPrefix(msg, "error: ") {
+		return errors.New(strings.TrimSpace(strings.TrimPrefix(msg, "error: ")))
+	}
+
+	_, err = os.Stdout.Write(reply)
+	return err
+}
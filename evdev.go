@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"os"
+	"syscall"
 	"time"
+	"unsafe"
 
 	"github.com/cenk/backoff"
 	"github.com/ghthor/chordpad/input"
@@ -66,6 +73,9 @@ var BtnIndex = map[int]input.Chord{
 type AbsPad struct {
 	offset ChordIndex
 	x, y   int32
+
+	// Set when an axis has moved since the pad was last updated.
+	moved bool
 }
 
 func (p AbsPad) Update(m input.Model) input.Model {
@@ -152,25 +162,34 @@ type touchpads struct {
 	left, right *AbsPad
 }
 
-func (t touchpads) Update(m input.Model, e evdev.AbsEvent) input.Model {
+// Move stores the position of a pad axis. The pad isn't classified
+// until Update because the x and y axes are separate events.
+func (t touchpads) Move(e evdev.AbsEvent) {
 	switch e.AxisCode {
 	case evdev.ABS_HAT0X:
-		t.left.x = e.Value
-		return t.left.Update(m)
+		t.left.x, t.left.moved = e.Value, true
 	case evdev.ABS_HAT0Y:
-		t.left.y = e.Value
-		return t.left.Update(m)
+		t.left.y, t.left.moved = e.Value, true
 
 	case evdev.ABS_RX:
-		t.right.x = e.Value
-		return t.right.Update(m)
+		t.right.x, t.right.moved = e.Value, true
 	case evdev.ABS_RY:
-		t.right.y = e.Value
-		return t.right.Update(m)
+		t.right.y, t.right.moved = e.Value, true
 
 	default:
 	}
-	return m
+}
+
+// Moved returns the pads that have moved since they were updated.
+func (t touchpads) Moved() []*AbsPad {
+	var moved []*AbsPad
+	for _, pad := range []*AbsPad{t.left, t.right} {
+		if pad.moved {
+			moved = append(moved, pad)
+			pad.moved = false
+		}
+	}
+	return moved
 }
 
 func newPadAxes() touchpads {
@@ -180,39 +199,334 @@ func newPadAxes() touchpads {
 	}
 }
 
-type steamController struct {
+// An eventSource produces the evdev input events that drive a
+// steamController. It is usually an evdev device but can also be a
+// recording of one. ReadOne returns the error of ctx if ctx is done
+// while it waits for an event.
+type eventSource interface {
+	ReadOne(ctx context.Context) (*evdev.InputEvent, error)
+	io.Closer
+}
+
+// evdevReadSize is the number of events read from an evdev device
+// with each read.
+const evdevReadSize = 64
+
+// An evdevSource reads input events from an evdev device node. The
+// device is polled so a read can be cancelled.
+type evdevSource struct {
 	*evdev.InputDevice
 
+	fd      int
+	poller  *poller
+	buf     []byte
+	pending []evdev.InputEvent
+}
+
+// newEvdevSource creates an evdevSource that reads from dev. The
+// device is closed if it can't be polled.
+func newEvdevSource(dev *evdev.InputDevice) (*evdevSource, error) {
+	// Fd puts the file into blocking mode which is undone because
+	// the device is only read once it has been polled.
+	fd := int(dev.File.Fd())
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		dev.File.Close()
+		return nil, err
+	}
+
+	p, err := newPoller(fd)
+	if err != nil {
+		dev.File.Close()
+		return nil, err
+	}
+
+	size := binary.Size(evdev.InputEvent{})
+	return &evdevSource{
+		InputDevice: dev,
+		fd:          fd,
+		poller:      p,
+		buf:         make([]byte, evdevReadSize*size),
+	}, nil
+}
+
+func (dev *evdevSource) ReadOne(ctx context.Context) (*evdev.InputEvent, error) {
+	for len(dev.pending) == 0 {
+		if err := dev.poller.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		n, err := syscall.Read(dev.fd, dev.buf)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "read", Path: dev.Fn, Err: err}
+		}
+		if n == 0 {
+			return nil, io.EOF
+		}
+
+		events := make([]evdev.InputEvent, n/binary.Size(evdev.InputEvent{}))
+		err = binary.Read(bytes.NewReader(dev.buf[:n]), binary.LittleEndian, events)
+		if err != nil {
+			return nil, err
+		}
+		dev.pending = events
+	}
+
+	e := &dev.pending[0]
+	dev.pending = dev.pending[1:]
+	return e, nil
+}
+
+func (dev *evdevSource) Close() error {
+	dev.poller.Close()
+	return dev.File.Close()
+}
+
+// A deviceState reports the current state of an evdev device. It
+// is used to resync a steamController when events are dropped.
+type deviceState interface {
+	KeyDown(code int) (bool, error)
+	AbsValue(axis int) (int32, error)
+}
+
+// deviceStateOf returns the deviceState of an eventSource, looking
+// through any sources that wrap another.
+func deviceStateOf(src eventSource) (deviceState, bool) {
+	for {
+		if state, isStateful := src.(deviceState); isStateful {
+			return state, true
+		}
+
+		wrapper, isWrapper := src.(interface{ Unwrap() eventSource })
+		if !isWrapper {
+			return nil, false
+		}
+		src = wrapper.Unwrap()
+	}
+}
+
+func (dev *evdevSource) ioctl(req int, data unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(dev.fd), uintptr(req), uintptr(data))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (dev *evdevSource) KeyDown(code int) (bool, error) {
+	var bits [evdev.MAX_NAME_SIZE]byte
+	if err := dev.ioctl(int(evdev.EVIOCGKEY), unsafe.Pointer(&bits)); err != nil {
+		return false, err
+	}
+	return bits[code/8]&(1<<uint(code%8)) != 0, nil
+}
+
+func (dev *evdevSource) AbsValue(axis int) (int32, error) {
+	// struct input_absinfo starts with the current value
+	var info [6]int32
+	if err := dev.ioctl(evdev.EVIOCGABS(axis), unsafe.Pointer(&info)); err != nil {
+		return 0, err
+	}
+	return info[0], nil
+}
+
+// RepeatRate returns the delay before a held key repeats and the
+// period between repeats that the device is configured with. The
+// kernel reports them as two 32 bit ints, which GetRepeatRate reads
+// into a pair of uints that are 64 bits wide on most platforms.
+func (dev *evdevSource) RepeatRate() (delay, period time.Duration, err error) {
+	var rate [2]uint32
+	if err := dev.ioctl(int(evdev.EVIOCGREP), unsafe.Pointer(&rate)); err != nil {
+		return 0, 0, err
+	}
+
+	delay = time.Duration(rate[evdev.REP_DELAY]) * time.Millisecond
+	period = time.Duration(rate[evdev.REP_PERIOD]) * time.Millisecond
+	return delay, period, nil
+}
+
+type steamController struct {
+	eventSource
+
 	touchpads
 	triggers
+
+	// State of each button that is bound, used to ignore key repeats
+	// and to resync after events are dropped.
+	buttons map[int]bool
 }
 
-func (dev steamController) Update(model input.Model) (input.Model, error) {
-	e, err := dev.ReadOne()
-	if err != nil {
-		return model, err
+func newSteamController(events eventSource) steamController {
+	return steamController{events, newPadAxes(), newTriggers(), make(map[int]bool)}
+}
+
+func (dev steamController) String() string {
+	return fmt.Sprint(dev.eventSource)
+}
+
+// Update reads the events of a frame, up to a SYN_REPORT, and
+// applies them to the model together. If the kernel dropped events
+// the frame is discarded and the state is read from the device.
+func (dev steamController) Update(ctx context.Context, model input.Model) (input.Model, error) {
+	var frame []*evdev.InputEvent
+	for {
+		e, err := dev.ReadOne(ctx)
+		if err != nil {
+			return model, err
+		}
+
+		if e.Type != evdev.EV_SYN {
+			frame = append(frame, e)
+			continue
+		}
+
+		switch e.Code {
+		case evdev.SYN_REPORT:
+			return dev.applyFrame(model, frame), nil
+
+		case evdev.SYN_DROPPED:
+			return dev.resync(ctx, model)
+
+		default:
+		}
+	}
+}
+
+// applyFrame applies the events of a frame to the model. A chord
+// played by an event is kept even if a later event of the frame
+// releases or presses another button.
+func (dev steamController) applyFrame(model input.Model, frame []*evdev.InputEvent) input.Model {
+	var played input.Chord
+
+	model.Trigger = 0
+	for _, e := range frame {
+		model = dev.apply(model, e)
+		if model.Trigger != 0 {
+			played = model.Trigger
+		}
+	}
+
+	for _, pad := range dev.touchpads.Moved() {
+		model = pad.Update(model)
+		if model.Trigger != 0 {
+			played = model.Trigger
+		}
+	}
+
+	model.Trigger = played
+	return model
+}
+
+// axis returns the value of an axis as it was last applied.
+func (dev steamController) axis(code int) int32 {
+	switch code {
+	case evdev.ABS_HAT0X:
+		return dev.left.x
+	case evdev.ABS_HAT0Y:
+		return dev.left.y
+	case evdev.ABS_RX:
+		return dev.right.x
+	case evdev.ABS_RY:
+		return dev.right.y
+	case evdev.ABS_Z, evdev.ABS_RZ:
+		return dev.triggers[code].value
+	default:
 	}
+	return 0
+}
 
-	if e.Type == evdev.EV_SYN {
+// resync discards the events up to the next SYN_REPORT and applies
+// the difference between the device state and the model instead.
+// Only the buttons and axes that changed are applied, so a pad that
+// is still resting where it was isn't lifted and touched again.
+func (dev steamController) resync(ctx context.Context, model input.Model) (input.Model, error) {
+	var report *evdev.InputEvent
+	for report == nil {
+		e, err := dev.ReadOne(ctx)
+		if err != nil {
+			return model, err
+		}
+
+		if e.Type == evdev.EV_SYN && e.Code == evdev.SYN_REPORT {
+			report = e
+		}
+	}
+
+	state, isStateful := deviceStateOf(dev.eventSource)
+	if !isStateful {
+		log.Println("input events were dropped and the device state can't be read")
 		return model, nil
 	}
 
+	log.Println("input events were dropped, reading the device state")
+
+	var frame []*evdev.InputEvent
+	event := func(typ uint16, code int, value int32) {
+		frame = append(frame, &evdev.InputEvent{
+			Time:  report.Time,
+			Type:  typ,
+			Code:  uint16(code),
+			Value: value,
+		})
+	}
+
+	for code := range BtnIndex {
+		down, err := state.KeyDown(code)
+		if err != nil {
+			return model, err
+		}
+
+		if down != dev.buttons[code] {
+			var value int32
+			if down {
+				value = 1
+			}
+			event(evdev.EV_KEY, code, value)
+		}
+	}
+
+	for _, axis := range steamControllerAxes {
+		value, err := state.AbsValue(axis)
+		if err != nil {
+			return model, err
+		}
+
+		if value != dev.axis(axis) {
+			event(evdev.EV_ABS, axis, value)
+		}
+	}
+
+	return dev.applyFrame(model, frame), nil
+}
+
+func (dev steamController) apply(model input.Model, e *evdev.InputEvent) input.Model {
+	model.Time = time.Unix(e.Time.Unix())
+
 	switch e.Type {
 	case evdev.EV_KEY:
 		ke := evdev.NewKeyEvent(e)
 
 		if index, exists := BtnIndex[int(ke.Scancode)]; exists {
-			return applyKey(ke.State)(model, index), nil
+			// Repeats of a button that is held are ignored
+			down := ke.State != evdev.KeyUp
+			if down == dev.buttons[int(ke.Scancode)] {
+				return model
+			}
+
+			dev.buttons[int(ke.Scancode)] = down
+			return applyKey(ke.State)(model, index)
 		}
 
 		// TODO: Bind all possible buttons
 		fmt.Println("unbound input: ", ke)
-		return model, nil
+		return model
 
 	case evdev.EV_REL:
 		// TODO: Map into a model change
 		//e := evdev.NewRelEvent(e)
-		return model, nil
+		return model
 
 	case evdev.EV_ABS:
 		abs := evdev.NewAbsEvent(e)
@@ -221,21 +535,17 @@ func (dev steamController) Update(model input.Model) (input.Model, error) {
 		case evdev.ABS_Z:
 			fallthrough
 		case evdev.ABS_RZ:
-			return dev.triggers[abs.AxisCode].Update(model, abs.Value), nil
+			return dev.triggers[abs.AxisCode].Update(model, abs.Value)
 		default:
-			return dev.touchpads.Update(model, *abs), nil
+			dev.touchpads.Move(*abs)
+			return model
 		}
-		return model, nil
 
 	default:
 	}
 
 	fmt.Println("unexpected input event: ", e.String())
-	return model, nil
-}
-
-func (dev steamController) Close() error {
-	return dev.File.Close()
+	return model
 }
 
 // ErrNoValidInputDevices is returned when no valid evdev input devices are found
@@ -247,35 +557,40 @@ func autoSelectDevice() (*evdev.InputDevice, error) {
 		return nil, err
 	}
 
-	if len(inputs) < 1 {
-		return nil, ErrNoValidInputDevices
+	// The first supported device is used and the rest are closed
+	var selected *evdev.InputDevice
+	for _, dev := range inputs {
+		if selected == nil && isSupportedDevice(dev) {
+			selected = dev
+			continue
+		}
+		dev.File.Close()
 	}
 
-	return inputs[0], nil
+	if selected == nil {
+		return nil, ErrNoValidInputDevices
+	}
+	return selected, nil
 }
 
-func autoSelectInputDeviceOp(output **evdev.InputDevice) func() error {
-	return func() error {
-		pad, err := autoSelectDevice()
-		switch err {
-		case ErrNoValidInputDevices:
-			log.Println(ErrNoValidInputDevices)
-			return ErrNoValidInputDevices
-
-		default:
-			log.Fatal(err)
-
-		case nil:
-		}
+// selectDevice opens the evdev device at path or auto selects a
+// device if path is empty.
+func selectDevice(path string) (*evdev.InputDevice, error) {
+	if path == "" {
+		return autoSelectDevice()
+	}
 
-		*output = pad
-		return nil
+	dev, err := evdev.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoValidInputDevices
 	}
+	return dev, err
 }
 
-func autoSelectEvdevDevice() input.Device {
-	var dev *evdev.InputDevice
-
+// waitForEvdevDevice blocks until the evdev device at path, or an
+// auto selected device if path is empty, can be opened or ctx is
+// done.
+func waitForEvdevDevice(ctx context.Context, path string) (*evdev.InputDevice, error) {
 	backoffConfig := backoff.ExponentialBackOff{
 		InitialInterval:     500 * time.Millisecond,
 		RandomizationFactor: 0.5,
@@ -286,11 +601,17 @@ func autoSelectEvdevDevice() input.Device {
 	}
 	backoffConfig.Reset()
 
-	err := backoff.Retry(autoSelectInputDeviceOp(&dev), &backoffConfig)
-	if err != nil {
-		log.Fatal(err)
-	}
+	for {
+		dev, err := selectDevice(path)
+		if err != ErrNoValidInputDevices {
+			return dev, err
+		}
+		log.Println(err)
 
-	// TODO: Map into different types of devices
-	return steamController{dev, newPadAxes(), newTriggers()}
+		select {
+		case <-time.After(backoffConfig.NextBackOff()):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+func TestAbsPad(t *testing.T) {
+	const far = 30000
+
+	cases := []struct {
+		name  string
+		pad   AbsPad
+		model input.Model
+		want  input.Model
+	}{{
+		"touching the left pad starts a chord",
+		AbsPad{offset: 0, x: 0, y: far},
+		input.Model{},
+		input.Model{Keys: 1 << PAD_N, Build: 1 << PAD_N},
+	}, {
+		"touching the right pad adds to the chord",
+		AbsPad{offset: 4, x: 0, y: -far},
+		input.Model{Keys: 1 << PAD_N, Build: 1 << PAD_N},
+		input.Model{Keys: 1<<PAD_N | 1<<(PAD_S+4), Build: 1<<PAD_N | 1<<(PAD_S+4)},
+	}, {
+		"sliding to another zone adds it",
+		AbsPad{offset: 0, x: far, y: 0},
+		input.Model{Keys: 1 << PAD_N, Build: 1 << PAD_N},
+		input.Model{Keys: 1<<PAD_N | 1<<PAD_E, Build: 1<<PAD_N | 1<<PAD_E},
+	}, {
+		"the deadzone doesn't change the chord",
+		AbsPad{offset: 0, x: 100, y: 100},
+		input.Model{Keys: 1 << PAD_W, Build: 1 << PAD_W},
+		input.Model{Keys: 1 << PAD_W, Build: 1 << PAD_W},
+	}, {
+		"lifting a thumb plays the chord",
+		AbsPad{offset: 0},
+		input.Model{Keys: 1<<PAD_N | 1<<(PAD_S+4), Build: 1<<PAD_N | 1<<(PAD_S+4)},
+		input.Model{Keys: 1 << (PAD_S + 4), Trigger: 1<<PAD_N | 1<<(PAD_S+4)},
+	}}
+
+	for _, c := range cases {
+		if got := c.pad.Update(c.model); got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAbsTrigger(t *testing.T) {
+	steps := []struct {
+		value int32
+		want  input.Model
+	}{
+		{100, input.Model{}},
+		{255, input.Model{Keys: BTN_TL1, Build: BTN_TL1}},
+		{255, input.Model{Keys: BTN_TL1, Build: BTN_TL1}},
+		{200, input.Model{Trigger: BTN_TL1}},
+		{0, input.Model{Trigger: BTN_TL1}},
+		{255, input.Model{Keys: BTN_TL1, Build: BTN_TL1}},
+	}
+
+	trigger := &AbsTrigger{output: BTN_TL1}
+	model := input.Model{}
+	for i, step := range steps {
+		model = trigger.Update(model, step.value)
+		if model != step.want {
+			t.Errorf("step %d, value %d: got %+v, want %+v", i, step.value, model, step.want)
+		}
+	}
+}
+
+func TestFlatMapModelChanges(t *testing.T) {
+	cases := []struct {
+		script string
+		want   []input.Model
+	}{{
+		"left N, right S, release",
+		[]input.Model{
+			{Keys: 4, Build: 4},
+			{Keys: 20, Build: 20},
+			{Trigger: 20},
+		},
+	}, {
+		// A frame that doesn't change the model isn't repeated
+		"left N, left N, left up",
+		[]input.Model{
+			{Keys: 4, Build: 4},
+			{Trigger: 4},
+		},
+	}, {
+		"press A, left N, release",
+		[]input.Model{
+			{Keys: MOD_CTRL, Build: MOD_CTRL},
+			{Keys: MOD_CTRL | 4, Build: MOD_CTRL | 4},
+			{Trigger: MOD_CTRL | 4},
+		},
+	}, {
+		"press LT, press TL, release",
+		[]input.Model{
+			{Keys: BTN_TL1, Build: BTN_TL1},
+			{Keys: BTN_TL1 | BTN_TL0, Build: BTN_TL1 | BTN_TL0},
+			{Trigger: BTN_TL1 | BTN_TL0},
+		},
+	}}
+
+	for _, c := range cases {
+		dev, err := NewSimulator(c.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		source := input.Source{Device: dev}
+		var got []input.Model
+		for m := range source.FlatMapModelChanges(context.Background()) {
+			got = append(got, input.Model{Keys: m.Keys, Build: m.Build, Trigger: m.Trigger})
+		}
+		if err := source.Err(); err != io.EOF {
+			t.Errorf("%q: got error %v, want EOF", c.script, err)
+		}
+
+		if len(got) != len(c.want) {
+			t.Errorf("%q: got %+v, want %+v", c.script, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%q: model %d is %+v, want %+v", c.script, i, got[i], c.want[i])
+			}
+		}
+	}
+}
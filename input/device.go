@@ -8,9 +8,10 @@ import (
 // A Device is the source for Model changes. Implementations of
 // Device should determine how the buttons or other inputs map
 // to changes in the Model that change the chord being keyed or
-// when to a chord should be played.
+// when to a chord should be played. Update should return promptly
+// with the error of ctx if ctx is done while waiting for input.
 type Device interface {
-	Update(Model) (Model, error)
+	Update(context.Context, Model) (Model, error)
 	io.Closer
 }
 
@@ -18,25 +19,37 @@ type Device interface {
 // channel of Model values from the Devices input events.
 type Source struct {
 	Device
-	Err error
+
+	// Timing combines near-simultaneous presses and releases into a
+	// single chord of Events. No presses or releases are combined if
+	// zero.
+	Timing Timing
+
+	err  error
+	done chan struct{}
 }
 
 // FlatMapModelChanges is used to read and apply input events and
-// produce a channel of Model values that will never repeat.
+// produce a channel of Model values that will never repeat. The
+// channel is closed when the Device fails or ctx is done.
 func (dev *Source) FlatMapModelChanges(ctx context.Context) <-chan Model {
 	model := Model{}
 	output := make(chan Model)
+	dev.done = make(chan struct{})
 
 	go func(output chan<- Model) {
 		defer close(output)
+		defer close(dev.done)
 		for {
-			nextModel, err := dev.Update(model)
+			nextModel, err := dev.Update(ctx, model)
 			if err != nil {
-				dev.Err = err
+				if ctx.Err() == nil {
+					dev.err = err
+				}
 				return
 			}
 
-			if nextModel == model {
+			if nextModel.Equal(model) {
 				continue
 			}
 
@@ -51,3 +64,21 @@ func (dev *Source) FlatMapModelChanges(ctx context.Context) <-chan Model {
 
 	return output
 }
+
+// Events reads the Device and produces a channel of the events of
+// each chord, combining presses and releases with the Timing. The
+// channel is closed when the Device fails or ctx is done.
+func (dev *Source) Events(ctx context.Context) <-chan Event {
+	return dev.Timing.Events(ctx, dev.FlatMapModelChanges(ctx))
+}
+
+// Err waits until the Device has stopped being read and returns the
+// error that stopped it, which is nil if the context was done or the
+// Device was never read with FlatMapModelChanges or Events.
+func (dev *Source) Err() error {
+	if dev.done == nil {
+		return nil
+	}
+	<-dev.done
+	return dev.err
+}
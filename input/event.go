@@ -0,0 +1,135 @@
+package input
+
+import (
+	"fmt"
+	"time"
+)
+
+// An Event is a step in the lifecycle of a chord. Keys go down and
+// start a chord, change it as more keys are added, and the chord is
+// either committed when a key is released or cancelled if it is
+// abandoned without being played, e.g. by a stage that pauses the
+// output while it is being built.
+type Event interface {
+	// When is the time of the input event that caused the Event.
+	When() time.Time
+}
+
+// KeyDown is the chord keys that were pressed.
+type KeyDown struct {
+	Keys Chord
+	Time time.Time
+}
+
+// KeyUp is the chord keys that were released.
+type KeyUp struct {
+	Keys Chord
+	Time time.Time
+}
+
+// ChordStarted is the first key of a chord being pressed.
+type ChordStarted struct {
+	Chord Chord
+	Time  time.Time
+}
+
+// ChordChanged is a chord being built gaining or losing keys.
+type ChordChanged struct {
+	From, To Chord
+	Time     time.Time
+}
+
+// ChordCommitted is a chord that was played.
+type ChordCommitted struct {
+	Chord Chord
+	Time  time.Time
+}
+
+// ChordCancelled is a chord that was abandoned without being played.
+type ChordCancelled struct {
+	Chord Chord
+	Time  time.Time
+}
+
+func (e KeyDown) When() time.Time        { return e.Time }
+func (e KeyUp) When() time.Time          { return e.Time }
+func (e ChordStarted) When() time.Time   { return e.Time }
+func (e ChordChanged) When() time.Time   { return e.Time }
+func (e ChordCommitted) When() time.Time { return e.Time }
+func (e ChordCancelled) When() time.Time { return e.Time }
+
+func (e KeyDown) String() string        { return fmt.Sprintf("key down %d", e.Keys) }
+func (e KeyUp) String() string          { return fmt.Sprintf("key up %d", e.Keys) }
+func (e ChordStarted) String() string   { return fmt.Sprintf("chord started %d", e.Chord) }
+func (e ChordChanged) String() string   { return fmt.Sprintf("chord changed %d to %d", e.From, e.To) }
+func (e ChordCommitted) String() string { return fmt.Sprintf("chord committed %d", e.Chord) }
+func (e ChordCancelled) String() string { return fmt.Sprintf("chord cancelled %d", e.Chord) }
+
+// Apply returns the model after an event. A model can be kept up to
+// date with a channel of events by applying each of them in turn.
+func (m Model) Apply(e Event) Model {
+	m.Time = e.When()
+	m.Trigger = 0
+
+	switch e := e.(type) {
+	case KeyDown:
+		m.Keys |= e.Keys
+	case KeyUp:
+		m.Keys &^= e.Keys
+	case ChordStarted:
+		m.Build = e.Chord
+	case ChordChanged:
+		m.Build = e.To
+	case ChordCommitted:
+		m.Build = 0
+		m.Trigger = e.Chord
+	case ChordCancelled:
+		m.Build = 0
+	default:
+	}
+	return m
+}
+
+// A tracker emits the events of each chord. It keeps the keys and
+// the chord being built as told by the events it has emitted, so
+// only what changed is emitted.
+type tracker struct {
+	keys  Chord
+	build Chord
+
+	events []Event
+}
+
+// press emits the keys that were pressed and released.
+func (t *tracker) press(keys Chord, at time.Time) {
+	if down := keys &^ t.keys; down != 0 {
+		t.events = append(t.events, KeyDown{down, at})
+	}
+	if up := t.keys &^ keys; up != 0 {
+		t.events = append(t.events, KeyUp{up, at})
+	}
+	t.keys = keys
+}
+
+// building emits the start, change or cancellation of the chord
+// being built.
+func (t *tracker) building(build Chord, at time.Time) {
+	switch {
+	case build == t.build:
+		return
+	case t.build == 0:
+		t.events = append(t.events, ChordStarted{build, at})
+	case build == 0:
+		t.events = append(t.events, ChordCancelled{t.build, at})
+	default:
+		t.events = append(t.events, ChordChanged{t.build, build, at})
+	}
+	t.build = build
+}
+
+// commit emits the commit of a chord, which ends the chord being
+// built.
+func (t *tracker) commit(chord Chord, at time.Time) {
+	t.events = append(t.events, ChordCommitted{chord, at})
+	t.build = 0
+}
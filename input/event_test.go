@@ -0,0 +1,31 @@
+package input
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns the time of an input event ms milliseconds into a test.
+func at(ms int) time.Time {
+	return time.Unix(1, 0).Add(time.Duration(ms) * time.Millisecond)
+}
+
+func TestModelApply(t *testing.T) {
+	var m Model
+	events := []Event{
+		KeyDown{Keys: 4, Time: at(0)},
+		ChordStarted{Chord: 4, Time: at(0)},
+		KeyDown{Keys: 16, Time: at(10)},
+		ChordChanged{From: 4, To: 20, Time: at(10)},
+		KeyUp{Keys: 4, Time: at(20)},
+		ChordCommitted{Chord: 20, Time: at(20)},
+	}
+	for _, e := range events {
+		m = m.Apply(e)
+	}
+
+	want := Model{Keys: 16, Trigger: 20, Time: at(20)}
+	if m != want {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+}
@@ -1,5 +1,7 @@
 package input
 
+import "time"
+
 // A Chord is used to store a bitfield of button states. Each
 // index in the the bitfield represents the on/off state of a
 // button associated to an input device.
@@ -14,6 +16,16 @@ type Model struct {
 
 	// Chord that's being played
 	Trigger Chord
+
+	// Time of the input event that produced the model
+	Time time.Time
+}
+
+// Equal reports whether two models have the same chord state. The
+// time of the models is ignored.
+func (m Model) Equal(other Model) bool {
+	m.Time = other.Time
+	return m == other
 }
 
 func KeysDown(m Model, keys Chord) Model {
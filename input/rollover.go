@@ -0,0 +1,41 @@
+package input
+
+// A rollover lets the next chord start before every key of the
+// chord before it is released. Once a key of a chord is released
+// and the chord is committed, the keys still held down are stale.
+// They aren't part of the chords built while they are held and
+// releasing them doesn't commit anything.
+type rollover struct {
+	keys  Chord
+	build Chord
+	stale Chord
+}
+
+// update converts a model change, where held keys are part of the
+// next chord, into one where they are stale.
+func (r *rollover) update(m Model) Model {
+	up := r.keys &^ m.Keys
+	down := m.Keys &^ r.keys
+	r.keys = m.Keys
+
+	released := up &^ r.stale
+	r.stale &^= up
+
+	switch {
+	case released != 0:
+		m.Trigger = r.build
+		r.build = down
+		r.stale = m.Keys &^ down
+
+	case m.Trigger != 0 && up != 0:
+		// Only stale keys were released
+		m.Trigger = 0
+		r.build |= down
+
+	default:
+		r.build = m.Build &^ r.stale
+	}
+
+	m.Build = r.build
+	return m
+}
@@ -0,0 +1,208 @@
+package input
+
+import (
+	"context"
+	"time"
+)
+
+// Timing configures how near-simultaneous presses and releases are
+// combined into a single chord. The windows are measured with the
+// times of the input events, not the time the models are received.
+type Timing struct {
+	// Keys pressed within PressWindow of the first key of a chord
+	// are part of the chord, even if the first key has already been
+	// released.
+	PressWindow time.Duration
+
+	// A chord isn't committed until ReleaseGrace after the release
+	// that played it. A key pressed during the grace is added to the
+	// chord, so a thumb that lifts off a pad for a moment doesn't
+	// play the chord twice.
+	ReleaseGrace time.Duration
+
+	// With Rollover the next chord can be started before every key
+	// of the one before it is released. Pressing a key that isn't
+	// part of a held back chord commits it and starts the next one.
+	Rollover bool
+}
+
+// A coalescer is the state machine that turns the model changes of
+// a Device into the events of each chord. It holds back the commit
+// of a chord until the Timing windows have passed.
+type coalescer struct {
+	Timing
+	tracker
+	rollover rollover
+
+	last    Model
+	started time.Time
+
+	// The commit being held back, the time of the release that
+	// played it and when it will be committed.
+	pending   Chord
+	releaseAt time.Time
+	deadline  time.Time
+
+	// Set when a key is pressed while a commit is held back. The
+	// commit waits for the release of that key instead of the
+	// deadline.
+	merged bool
+}
+
+// flush commits the held back chord at the time of the release that
+// played it.
+func (c *coalescer) flush() {
+	c.commit(c.pending, c.releaseAt)
+	c.pending = 0
+	c.merged = false
+}
+
+// expire commits the held back chord once its deadline has passed
+// without another model change.
+func (c *coalescer) expire() []Event {
+	c.events = nil
+	if c.pending != 0 {
+		at := c.releaseAt
+		c.flush()
+		c.building(c.last.Build, at)
+	}
+	return c.events
+}
+
+// update returns the events of a model change and how long after it
+// the held back commit must be flushed, if at all.
+func (c *coalescer) update(m Model) (events []Event, wait time.Duration) {
+	c.events = nil
+	if c.Rollover {
+		m = c.rollover.update(m)
+	}
+
+	if c.pending != 0 && !c.merged && !m.Time.Before(c.deadline) {
+		c.flush()
+	}
+
+	// The next chord was started before the grace ended
+	if c.Rollover && c.pending != 0 && m.Build&^c.pending != 0 {
+		c.flush()
+		c.started = m.Time
+	}
+
+	if c.pending == 0 && c.last.Build == 0 && m.Build != 0 {
+		c.started = m.Time
+	}
+	c.last = m
+	c.press(m.Keys, m.Time)
+
+	switch {
+	case m.Trigger != 0:
+		c.pending |= m.Trigger
+		c.merged = false
+		c.releaseAt = m.Time
+
+		c.deadline = m.Time.Add(c.ReleaseGrace)
+		if end := c.started.Add(c.PressWindow); end.After(c.deadline) {
+			c.deadline = end
+		}
+
+		if !c.deadline.After(m.Time) {
+			c.flush()
+			c.building(m.Build, m.Time)
+			return c.events, 0
+		}
+
+		c.building(m.Build|c.pending, m.Time)
+		return c.events, c.deadline.Sub(m.Time)
+
+	case c.pending != 0:
+		if m.Keys != 0 {
+			c.merged = true
+		}
+
+		c.building(m.Build|c.pending, m.Time)
+		if !c.merged {
+			wait = c.deadline.Sub(m.Time)
+		}
+		return c.events, wait
+
+	default:
+	}
+
+	c.building(m.Build, m.Time)
+	return c.events, 0
+}
+
+// Events applies the Timing to a channel of model changes and
+// produces the events of each chord. Commits that are held back are
+// flushed after the windows have passed even if no further model
+// changes arrive. The windows are measured from the time of the event
+// that opened them, so a model that was delayed on its way is still
+// combined by its own time.
+func (t Timing) Events(ctx context.Context, changes <-chan Model) <-chan Event {
+	output := make(chan Event)
+
+	go func() {
+		defer close(output)
+
+		c := coalescer{Timing: t}
+		var flush <-chan time.Time
+
+		send := func(events []Event) bool {
+			for _, e := range events {
+				select {
+				case output <- e:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		}
+
+		// update applies a model change, or the end of them, and
+		// reports whether to go on
+		update := func(m Model, ok bool) bool {
+			if !ok {
+				send(c.expire())
+				return false
+			}
+
+			events, wait := c.update(m)
+			flush = nil
+			if wait > 0 {
+				flush = time.After(wait)
+			}
+			return send(events)
+		}
+
+		for {
+			select {
+			case m, ok := <-changes:
+				if !update(m, ok) {
+					return
+				}
+
+			case <-flush:
+				// The timer only measures the wait on the wall clock. A
+				// model that is already waiting decides by the time of
+				// its event whether it came before the deadline.
+				select {
+				case m, ok := <-changes:
+					if !update(m, ok) {
+						return
+					}
+					continue
+				default:
+				}
+
+				flush = nil
+				if !send(c.expire()) {
+					return
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return output
+}
@@ -0,0 +1,156 @@
+package input
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The models a pad produces for left N and right S (4|16) pressed
+// one after the other and released in the same order.
+var chordOfTwoPads = []Model{
+	{Keys: 4, Build: 4, Time: at(0)},
+	{Keys: 20, Build: 20, Time: at(10)},
+	{Keys: 16, Trigger: 20, Time: at(20)},
+	{Time: at(30)},
+}
+
+func TestCoalescer(t *testing.T) {
+	cases := []struct {
+		name   string
+		timing Timing
+		models []Model
+		want   []string
+	}{{
+		"commits on the first release",
+		Timing{},
+		chordOfTwoPads,
+		[]string{
+			"key down 4", "chord started 4",
+			"key down 16", "chord changed 4 to 20",
+			"key up 4", "chord committed 20",
+			"key up 16",
+		},
+	}, {
+		"a press within the release grace is added",
+		Timing{ReleaseGrace: 15 * time.Millisecond},
+		[]Model{
+			{Keys: 4, Build: 4, Time: at(0)},
+			{Trigger: 4, Time: at(20)},
+			{Keys: 16, Build: 16, Time: at(25)},
+			{Trigger: 16, Time: at(40)},
+		},
+		[]string{
+			"key down 4", "chord started 4",
+			"key up 4",
+			"key down 16", "chord changed 4 to 20",
+			"key up 16", "chord committed 20",
+		},
+	}, {
+		"a press after the release grace starts the next chord",
+		Timing{ReleaseGrace: 15 * time.Millisecond},
+		[]Model{
+			{Keys: 4, Build: 4, Time: at(0)},
+			{Trigger: 4, Time: at(20)},
+			{Keys: 16, Build: 16, Time: at(50)},
+			{Trigger: 16, Time: at(60)},
+		},
+		[]string{
+			"key down 4", "chord started 4",
+			"key up 4",
+			"chord committed 4", "key down 16", "chord started 16",
+			"key up 16", "chord committed 16",
+		},
+	}, {
+		"a late press within the press window is added",
+		Timing{PressWindow: 30 * time.Millisecond},
+		[]Model{
+			{Keys: 4, Build: 4, Time: at(0)},
+			{Trigger: 4, Time: at(10)},
+			{Keys: 16, Build: 16, Time: at(20)},
+			{Trigger: 16, Time: at(35)},
+		},
+		[]string{
+			"key down 4", "chord started 4",
+			"key up 4",
+			"key down 16", "chord changed 4 to 20",
+			"key up 16", "chord committed 20",
+		},
+	}, {
+		"without rollover a held key is part of the next chord",
+		Timing{},
+		[]Model{
+			{Keys: 4, Build: 4, Time: at(0)},
+			{Keys: 20, Build: 20, Time: at(10)},
+			{Keys: 16, Trigger: 20, Time: at(20)},
+			{Keys: 18, Build: 18, Time: at(30)},
+			{Keys: 16, Trigger: 18, Time: at(40)},
+			{Time: at(50)},
+		},
+		[]string{
+			"key down 4", "chord started 4",
+			"key down 16", "chord changed 4 to 20",
+			"key up 4", "chord committed 20",
+			"key down 2", "chord started 18",
+			"key up 2", "chord committed 18",
+			"key up 16",
+		},
+	}, {
+		"with rollover a held key is stale",
+		Timing{Rollover: true},
+		[]Model{
+			{Keys: 4, Build: 4, Time: at(0)},
+			{Keys: 20, Build: 20, Time: at(10)},
+			{Keys: 16, Trigger: 20, Time: at(20)},
+			{Keys: 18, Build: 18, Time: at(30)},
+			{Keys: 16, Trigger: 18, Time: at(40)},
+			{Time: at(50)},
+		},
+		[]string{
+			"key down 4", "chord started 4",
+			"key down 16", "chord changed 4 to 20",
+			"key up 4", "chord committed 20",
+			"key down 2", "chord started 2",
+			"key up 2", "chord committed 2",
+			"key up 16",
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			coalescer := coalescer{Timing: c.timing}
+
+			var events []Event
+			for _, m := range c.models {
+				e, _ := coalescer.update(m)
+				events = append(events, e...)
+			}
+			events = append(events, coalescer.expire()...)
+
+			var got []string
+			for _, e := range events {
+				got = append(got, fmt.Sprint(e))
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCoalescerCommitsAtTheRelease(t *testing.T) {
+	coalescer := coalescer{Timing: Timing{ReleaseGrace: 15 * time.Millisecond}}
+	coalescer.update(Model{Keys: 4, Build: 4, Time: at(0)})
+
+	_, wait := coalescer.update(Model{Trigger: 4, Time: at(20)})
+	if wait != 15*time.Millisecond {
+		t.Errorf("waits %v to flush, want 15ms", wait)
+	}
+
+	events := coalescer.expire()
+	want := ChordCommitted{Chord: 4, Time: at(20)}
+	if len(events) != 1 || events[0] != want {
+		t.Errorf("got %v, want %v", events, want)
+	}
+}
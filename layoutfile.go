@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ghthor/chordpad/input"
+	evdev "github.com/ghthor/golang-evdev"
+	"github.com/ghthor/uinput"
+)
+
+// Layout files are JSON objects that map a chord, written as a
+// decimal number, to the name of the key it outputs. A key name
+// can be prefixed with modifiers, e.g.
+//
+//	{"4": "KEY_E", "136": "KEY_BACKSPACE", "40": "SHIFT+KEY_1"}
+//
+// A TapHold is written as the key that is tapped and the modifier
+// that is held separated by a slash, e.g. "KEY_SPACE/SHIFT". Undo
+// and Retry are written as "UNDO" and "RETRY".
+//
+// A braille cell is written as its grade and dots, e.g. "BRAILLE-125"
+// for a Grade 1 h or "BRAILLE2-125" for a Grade 2 h.
+//
+// A chord bound to "LEADER" starts a sequence of chords. Sequences
+// are written as the leader followed by the chords played after it,
+// separated by spaces, e.g. {"20": "LEADER", "20 9 40": "ALT+KEY_F4"}.
+
+var keyModifiers = []struct {
+	name string
+	key  int
+}{
+	{"SHIFT", uinput.KEY_RIGHTSHIFT},
+	{"CTRL", uinput.KEY_RIGHTCTRL},
+	{"ALT", uinput.KEY_RIGHTALT},
+	{"META", uinput.KEY_RIGHTMETA},
+}
+
+var keyCodes = func() map[string]int {
+	codes := make(map[string]int, len(evdev.KEY))
+	for code, name := range evdev.KEY {
+		codes[name] = code
+	}
+	return codes
+}()
+
+func keyName(code int) string {
+	if name, exists := evdev.KEY[code]; exists {
+		return name
+	}
+	return strconv.Itoa(code)
+}
+
+func isLetter(code int) bool {
+	for _, letter := range alphabet {
+		if code == letter {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKey converts the name of a key, with optional modifiers, into
+// an OutputEvent.
+func parseKey(name string) (OutputEvent, error) {
+	switch name {
+	case "UNDO":
+		return Undo{}, nil
+	case "RETRY":
+		return Retry{}, nil
+	case "LEADER":
+		return NewLeader(), nil
+	default:
+	}
+
+	for grade, prefix := range []string{"BRAILLE-", "BRAILLE2-"} {
+		if numbers, isCell := strings.CutPrefix(name, prefix); isCell {
+			cell, err := parseDots(numbers)
+			return BrailleCell{cell, grade + 1}, err
+		}
+	}
+
+	if tap, hold, isDual := strings.Cut(name, "/"); isDual {
+		key, err := parseKey(tap)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, mod := range keyModifiers {
+			if hold == mod.name {
+				return TapHold{key, mod.key}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown modifier %q in %q", hold, name)
+	}
+
+	parts := strings.Split(name, "+")
+
+	code, exists := keyCodes[parts[len(parts)-1]]
+	if !exists {
+		return nil, fmt.Errorf("unknown key %q", name)
+	}
+
+	var key OutputEvent = Func(code)
+	switch {
+	case isLetter(code):
+		key = Letter(code)
+	case uinput.KEY_1 <= code && code <= uinput.KEY_0:
+		key = Num(code)
+	default:
+	}
+
+nextModifier:
+	for i := len(parts) - 2; i >= 0; i-- {
+		for _, mod := range keyModifiers {
+			if parts[i] == mod.name {
+				key = Wrap{key, mod.key}
+				continue nextModifier
+			}
+		}
+		return nil, fmt.Errorf("unknown modifier %q in %q", parts[i], name)
+	}
+
+	return key, nil
+}
+
+// formatKey is the inverse of parseKey.
+func formatKey(key OutputEvent) (string, error) {
+	switch key := key.(type) {
+	case Letter:
+		return keyName(int(key)), nil
+	case Func:
+		return keyName(int(key)), nil
+	case Num:
+		return keyName(int(key)), nil
+	case ShiftPlus:
+		return formatKey(Wrap{key.OutputEvent, uinput.KEY_RIGHTSHIFT})
+	case Wrap:
+		inner, err := formatKey(key.OutputEvent)
+		if err != nil {
+			return "", err
+		}
+
+		for _, mod := range keyModifiers {
+			if mod.key == key.mod {
+				return mod.name + "+" + inner, nil
+			}
+		}
+		return "", fmt.Errorf("unknown modifier key %d", key.mod)
+	case TapHold:
+		tap, err := formatKey(key.Tap)
+		if err != nil {
+			return "", err
+		}
+
+		for _, mod := range keyModifiers {
+			if mod.key == key.Hold {
+				return tap + "/" + mod.name, nil
+			}
+		}
+		return "", fmt.Errorf("unknown modifier key %d", key.Hold)
+	case Undo:
+		return "UNDO", nil
+	case Retry:
+		return "RETRY", nil
+	case Leader:
+		return "LEADER", nil
+	case BrailleCell:
+		if key.Grade >= 2 {
+			return "BRAILLE2-" + formatDots(key.Dots), nil
+		}
+		return "BRAILLE-" + formatDots(key.Dots), nil
+	default:
+	}
+
+	return "", fmt.Errorf("%T can't be written to a layout file", key)
+}
+
+// LoadLayout reads a layout file.
+func LoadLayout(path string) (Layout, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var bindings map[string]string
+	if err := json.NewDecoder(f).Decode(&bindings); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	layout := make(Layout, len(bindings))
+	sequences := make(map[string]OutputEvent)
+	for chord, name := range bindings {
+		key, err := parseKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		// Sequences are added once every leader is known
+		if strings.Contains(chord, " ") {
+			sequences[chord] = key
+			continue
+		}
+
+		c, err := strconv.ParseUint(chord, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid chord %q", path, chord)
+		}
+		layout[input.Chord(c)] = key
+	}
+
+	for sequence, key := range sequences {
+		chords, err := parseSequence(sequence)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(chords) < 2 {
+			return nil, fmt.Errorf("%s: sequence %q has no chords after its leader", path, sequence)
+		}
+
+		leader, isLeader := layout[chords[0]].(Leader)
+		if !isLeader {
+			return nil, fmt.Errorf("%s: sequence %q doesn't start with a chord bound to LEADER", path, sequence)
+		}
+		leader.Sequences.Add(chords[1:], key)
+	}
+
+	return layout, nil
+}
+
+// WriteLayoutJSON writes a layout in the format read by LoadLayout.
+// The sequences of a leader are written after it.
+func WriteLayoutJSON(w io.Writer, layout Layout) error {
+	var entries [][2]string
+	for _, chord := range layout.Chords() {
+		name, err := formatKey(layout[chord])
+		if err != nil {
+			return err
+		}
+		entries = append(entries, [2]string{strconv.Itoa(int(chord)), name})
+
+		leader, isLeader := layout[chord].(Leader)
+		if !isLeader {
+			continue
+		}
+
+		err = leader.Sequences.Walk(func(chords []input.Chord, key OutputEvent) error {
+			name, err := formatKey(key)
+			if err != nil {
+				return err
+			}
+
+			sequence := formatSequence(append([]input.Chord{chord}, chords...))
+			entries = append(entries, [2]string{sequence, name})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	b := bytes.Buffer{}
+	b.WriteString("{\n")
+	for i, entry := range entries {
+		fmt.Fprintf(&b, "\t%q: %q", entry[0], entry[1])
+		if i < len(entries)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n")
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+var funcNames = map[int]string{
+	int(FN_SPACE): "FN_SPACE",
+	FN_TAB:        "FN_TAB",
+	FN_BACKSPACE:  "FN_BACKSPACE",
+	FN_DELETE:     "FN_DELETE",
+	FN_ENTER:      "FN_ENTER",
+	FN_ESCAPE:     "FN_ESCAPE",
+}
+
+func goSource(key OutputEvent) (string, error) {
+	switch key := key.(type) {
+	case Letter:
+		return fmt.Sprintf("Letter(uinput.%s)", keyName(int(key))), nil
+	case Num:
+		return fmt.Sprintf("Num(uinput.%s)", keyName(int(key))), nil
+	case Func:
+		if name, exists := funcNames[int(key)]; exists {
+			return fmt.Sprintf("Func(%s)", name), nil
+		}
+		return fmt.Sprintf("Func(uinput.%s)", keyName(int(key))), nil
+	case ShiftPlus:
+		inner, err := goSource(key.OutputEvent)
+		return fmt.Sprintf("ShiftPlus{%s}", inner), err
+	case Wrap:
+		inner, err := goSource(key.OutputEvent)
+		return fmt.Sprintf("Wrap{%s, uinput.%s}", inner, keyName(key.mod)), err
+	case TapHold:
+		tap, err := goSource(key.Tap)
+		return fmt.Sprintf("TapHold{%s, uinput.%s}", tap, keyName(key.Hold)), err
+	case Undo:
+		return "Undo{}", nil
+	case Retry:
+		return "Retry{}", nil
+	case BrailleCell:
+		return fmt.Sprintf("BrailleCell{%#02x, %d}", key.Dots, key.Grade), nil
+	case Leader:
+		return leaderSource(key)
+	default:
+	}
+
+	return "", fmt.Errorf("%T can't be written as Go source", key)
+}
+
+// leaderSource writes a Leader as a function that adds each of its
+// sequences to a new Leader.
+func leaderSource(leader Leader) (string, error) {
+	b := bytes.Buffer{}
+	b.WriteString("func() Leader {\nleader := NewLeader()\n")
+	err := leader.Sequences.Walk(func(chords []input.Chord, key OutputEvent) error {
+		src, err := goSource(key)
+		if err != nil {
+			return err
+		}
+
+		words := make([]string, len(chords))
+		for i, chord := range chords {
+			words[i] = strconv.Itoa(int(chord))
+		}
+		fmt.Fprintf(&b, "leader.Sequences.Add([]input.Chord{%s}, %s)\n", strings.Join(words, ", "), src)
+		return nil
+	})
+	b.WriteString("return leader\n}()")
+	return b.String(), err
+}
+
+// WriteLayoutGo writes a layout as Go source that declares a Layout
+// variable, in the same form as the Chords in bindings.go.
+func WriteLayoutGo(w io.Writer, name, comment string, layout Layout) error {
+	bindings := bytes.Buffer{}
+	for _, chord := range layout.Chords() {
+		src, err := goSource(layout[chord])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&bindings, "\t%d: %s,\n", chord, src)
+	}
+
+	b := bytes.Buffer{}
+	b.WriteString("package main\n\n")
+	var imports []string
+	if bytes.Contains(bindings.Bytes(), []byte("[]input.Chord")) {
+		imports = append(imports, `"github.com/ghthor/chordpad/input"`)
+	}
+	if bytes.Contains(bindings.Bytes(), []byte("uinput.")) {
+		imports = append(imports, `"github.com/ghthor/uinput"`)
+	}
+	if len(imports) > 0 {
+		fmt.Fprintf(&b, "import (\n\t%s\n)\n\n", strings.Join(imports, "\n\t"))
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(&b, "// %s\n", line)
+	}
+	fmt.Fprintf(&b, "var %s = Layout{\n", name)
+	b.Write(bindings.Bytes())
+	b.WriteString("}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(src)
+	return err
+}
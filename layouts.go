@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// A Keymap looks up the OutputEvent a chord plays.
+type Keymap interface {
+	Lookup(chord input.Chord) (OutputEvent, bool)
+}
+
+// A Layout binds chords to the OutputEvent they play.
+type Layout map[input.Chord]OutputEvent
+
+// Lookup returns the OutputEvent bound to a chord with any modifier
+// bits in the chord applied to it.
+func (l Layout) Lookup(chord input.Chord) (OutputEvent, bool) {
+	key, isBound := l[chord&^MOD_ALL]
+	if !isBound {
+		return nil, false
+	}
+
+	if chord&MOD_ALL != 0 {
+		key = applyModifiersTo(key, chord&MOD_ALL)
+	}
+
+	return key, true
+}
+
+// Chords returns every chord that is bound in the layout in
+// ascending order.
+func (l Layout) Chords() []input.Chord {
+	chords := make([]input.Chord, 0, len(l))
+	for chord := range l {
+		chords = append(chords, chord)
+	}
+	sortChords(chords)
+	return chords
+}
+
+// DefaultLayout is the name of the layout used unless another is
+// selected.
+const DefaultLayout = "chordpad"
+
+// Layouts are the built in layouts that can be selected by name.
+var Layouts = map[string]Layout{
+	"chordpad": Chords,
+	"asetniop": newViveLayout(asetniopChordMap),
+	"braille":  NewBrailleLayout(DefaultBrailleDots, 1),
+	"braille2": NewBrailleLayout(DefaultBrailleDots, 2),
+}
+
+// LayoutNames returns the names of the built in layouts.
+func LayoutNames() []string {
+	var names []string
+	for name := range Layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupLayout returns the built in layout with the given name or
+// loads the layout file if the name ends in .json.
+func LookupLayout(name string) (Layout, error) {
+	if layout, exists := Layouts[name]; exists {
+		return layout, nil
+	}
+
+	if strings.HasSuffix(name, ".json") {
+		return LoadLayout(name)
+	}
+
+	return nil, fmt.Errorf("unknown layout %q, expected one of %s",
+		name, strings.Join(LayoutNames(), ", "))
+}
+
+func layoutUsage() string {
+	return "layout used to bind chords to output, one of " + strings.Join(LayoutNames(), ", ") +
+		" or the path to a .json layout file"
+}
+
+// layoutFlag adds a -layout flag to a FlagSet. The returned func
+// looks up the selected layout after the FlagSet is parsed.
+func layoutFlag(flags *flag.FlagSet) func() (Layout, error) {
+	name := flags.String("layout", DefaultLayout, layoutUsage())
+	return func() (Layout, error) {
+		return LookupLayout(*name)
+	}
+}
+
+// A viveBinding is an entry of the ChordMap used by the Vive
+// experiment in experiments/SteamVR/Assets/ViveChord/ChordInput.cs.
+// The chord uses the bit order of TouchpadButtonMask4 and the key
+// is the name of a Windows VirtualKeyCode.
+type viveBinding struct {
+	chord input.Chord
+	key   string
+}
+
+// viveZones maps the bit index of each TouchpadButtonMask4 zone,
+// S, W, N, E, to the ChordIndex of the same zone of an AbsPad.
+var viveZones = [4]ChordIndex{PAD_S, PAD_W, PAD_N, PAD_E}
+
+// viveChord converts a chord from the bit order of the Vive
+// experiment into the bit order of a steamController.
+func viveChord(chord input.Chord) input.Chord {
+	converted := input.Chord(0)
+	for _, offset := range padOffsets {
+		for i, zone := range viveZones {
+			if chord&(1<<(offset+ChordIndex(i))) != 0 {
+				converted |= 1 << (offset + zone)
+			}
+		}
+	}
+	return converted
+}
+
+func newViveLayout(chordMap []viveBinding) Layout {
+	layout := make(Layout, len(chordMap))
+	for _, binding := range chordMap {
+		key, exists := VirtualKeys[binding.key]
+		if !exists {
+			panic(fmt.Sprintf("no uinput key for VirtualKeyCode.%s", binding.key))
+		}
+
+		layout[viveChord(binding.chord)] = key
+	}
+	return layout
+}
+
+// asetniopChordMap is the ASETNIOP derived layout practiced with
+// the Vive experiment.
+var asetniopChordMap = []viveBinding{
+	{2 + 32, "BACK"},  // (<) (<)
+	{1 + 16, "SPACE"}, // (v) (v)
+
+	// Left Hand, Home Row
+	{1, "VK_E"}, // (v) ( )
+	{2, "VK_A"}, // (<) ( )
+	{4, "VK_S"}, // (^) ( )
+	{8, "VK_T"}, // (>) ( )
+
+	// Right Hand, Home Row
+	{16, "VK_I"},  // ( ) (v)
+	{32, "VK_N"},  // ( ) (<)
+	{64, "VK_P"},  // ( ) (^)
+	{128, "VK_O"}, // ( ) (>)
+
+	{1 + 8, "VK_R"},    // (v>) ( )
+	{16 + 32, "VK_H"},  // ( )  (v<)
+	{2 + 8, "VK_D"},    // (<>) ( )
+	{32 + 128, "VK_M"}, // ( )  (<>)
+
+	{1 + 2, "VK_C"},    // (v<) ( )
+	{16 + 128, "VK_U"}, // ( )  (v>)
+
+	{1 + 4, "VK_F"},   // (v^) ( )
+	{16 + 64, "VK_L"}, // ( ) (v^)
+
+	{1 + 32, "VK_V"}, // (v) (<)
+	{16 + 8, "VK_Y"}, // (>) (v)
+
+	{1 + 128, "VK_G"}, // (v) (>)
+	{16 + 2, "VK_J"},  // (<) (v)
+
+	{4 + 2, "VK_W"},      // (^<) ( )
+	{4 + 1 + 16, "VK_B"}, // (^v) (v)
+	{64 + 128, "OEM_1"},  // ( ) (^>) ';:' Key
+
+	{4 + 8, "VK_X"},   // (^>) ( )
+	{64 + 32, "VK_K"}, // ( ) (^<)
+
+	{2 + 128, "VK_Q"}, // (<) (>)
+	{8 + 32, "VK_Z"},  // (>) (<)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghthor/chordpad/input"
+	"github.com/ghthor/uinput"
+)
+
+func TestLayoutLookup(t *testing.T) {
+	layout := Layout{
+		1 << PAD_N: Letter(uinput.KEY_E),
+		1 << PAD_E: TapHold{Letter(uinput.KEY_T), uinput.KEY_LEFTSHIFT},
+	}
+
+	cases := []struct {
+		chord   input.Chord
+		want    OutputEvent
+		isBound bool
+	}{
+		{1 << PAD_N, Letter(uinput.KEY_E), true},
+		{1<<PAD_N | MOD_SHIFT, ShiftPlus{Letter(uinput.KEY_E)}, true},
+		{1<<PAD_N | MOD_CTRL, Wrap{Letter(uinput.KEY_E), uinput.KEY_RIGHTCTRL}, true},
+		{1<<PAD_E | MOD_SHIFT, TapHold{ShiftPlus{Letter(uinput.KEY_T)}, uinput.KEY_LEFTSHIFT}, true},
+		{1 << PAD_S, nil, false},
+		{MOD_SHIFT, nil, false},
+	}
+
+	for _, c := range cases {
+		key, isBound := layout.Lookup(c.chord)
+		if isBound != c.isBound || !reflect.DeepEqual(key, c.want) {
+			t.Errorf("chord %d: got %v, %v, want %v, %v", c.chord, key, isBound, c.want, c.isBound)
+		}
+	}
+}
+
+func TestLookupLayout(t *testing.T) {
+	for _, name := range LayoutNames() {
+		layout, err := LookupLayout(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(layout) == 0 {
+			t.Errorf("%s is empty", name)
+		}
+	}
+
+	if _, err := LookupLayout("qwerty"); err == nil {
+		t.Error("an unknown layout was found")
+	}
+}
+
+func TestViveChord(t *testing.T) {
+	cases := []struct {
+		chord input.Chord
+		want  input.Chord
+	}{
+		{1, 1 << PAD_S},
+		{2, 1 << PAD_W},
+		{4, 1 << PAD_N},
+		{8, 1 << PAD_E},
+		{16, 1 << (PAD_S + 4)},
+		{2 + 32, 1<<PAD_W | 1<<(PAD_W+4)},
+		{4 + 1 + 16, 1<<PAD_N | 1<<PAD_S | 1<<(PAD_S+4)},
+	}
+
+	for _, c := range cases {
+		if got := viveChord(c.chord); got != c.want {
+			t.Errorf("vive chord %d: got %d, want %d", c.chord, got, c.want)
+		}
+	}
+}
+
+func TestAsetniop(t *testing.T) {
+	script := "left S, left up, left W, left up, right N, right up"
+	if got := typed(t, script, Layouts["asetniop"], input.Timing{}, Player{}); got != "E A P" {
+		t.Errorf("%q typed %q, want %q", script, got, "E A P")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ghthor/chordpad/input"
+	"github.com/ghthor/uinput"
+)
+
+// alphabet is the uinput key code of each letter from A to Z.
+var alphabet = [26]int{
+	uinput.KEY_A, uinput.KEY_B, uinput.KEY_C, uinput.KEY_D, uinput.KEY_E,
+	uinput.KEY_F, uinput.KEY_G, uinput.KEY_H, uinput.KEY_I, uinput.KEY_J,
+	uinput.KEY_K, uinput.KEY_L, uinput.KEY_M, uinput.KEY_N, uinput.KEY_O,
+	uinput.KEY_P, uinput.KEY_Q, uinput.KEY_R, uinput.KEY_S, uinput.KEY_T,
+	uinput.KEY_U, uinput.KEY_V, uinput.KEY_W, uinput.KEY_X, uinput.KEY_Y,
+	uinput.KEY_Z,
+}
+
+// padOffsets is the ChordIndex of the first bit of each touchpad.
+var padOffsets = []ChordIndex{0, 4}
+
+var padNames = []string{"left", "right"}
+
+// producibleBits returns every chord bit that one of the inputs of
+// a steamController can set.
+func producibleBits() input.Chord {
+	bits := input.Chord(0)
+	for _, offset := range padOffsets {
+		bits |= PAD_ALL << offset
+	}
+
+	for _, bit := range BtnIndex {
+		bits |= bit
+	}
+
+	for _, trigger := range newTriggers() {
+		bits |= trigger.output
+	}
+
+	return bits
+}
+
+// padZones returns the number of zones of each touchpad that are
+// part of a chord.
+func padZones(chord input.Chord) []int {
+	zones := make([]int, len(padOffsets))
+	for i, offset := range padOffsets {
+		for bits := (chord >> offset) & PAD_ALL; bits != 0; bits &= bits - 1 {
+			zones[i]++
+		}
+	}
+	return zones
+}
+
+// A LayoutProblem is an issue found in a layout by CheckLayout.
+type LayoutProblem struct {
+	Kind    string
+	Chords  []input.Chord
+	Message string
+}
+
+// IsNote reports whether the problem is informational. A chord
+// that needs several zones of one pad can still be played by
+// sliding a thumb from zone to zone.
+func (p LayoutProblem) IsNote() bool {
+	return p.Kind == "slide"
+}
+
+func (p LayoutProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Kind, p.Message)
+}
+
+func sortChords(chords []input.Chord) {
+	sort.Slice(chords, func(i, j int) bool { return chords[i] < chords[j] })
+}
+
+func formatChords(chords []input.Chord) string {
+	var s []string
+	for _, chord := range chords {
+		s = append(s, fmt.Sprint(chord))
+	}
+	return strings.Join(s, ", ")
+}
+
+// lintOutput describes the output of a key. Unlike describeOutput
+// it tells leaders apart by their sequences and a TapHold by both
+// of its roles.
+func lintOutput(key OutputEvent) string {
+	switch key := key.(type) {
+	case Leader:
+		var sequences []string
+		key.Sequences.Walk(func(chords []input.Chord, key OutputEvent) error {
+			sequences = append(sequences, fmt.Sprintf("%s => %s", formatSequence(chords), lintOutput(key)))
+			return nil
+		})
+		return fmt.Sprintf("leader of %s", strings.Join(sequences, ", "))
+	case TapHold:
+		return fmt.Sprintf("%s or hold %s", lintOutput(key.Tap), keyName(key.Hold))
+	default:
+	}
+	return describeOutput(key)
+}
+
+// typedOutputs describes each output a key types: the keys bound
+// to the sequences of a Leader, the Tap of a TapHold and the letter
+// or punctuation of a BrailleCell.
+func typedOutputs(key OutputEvent) []string {
+	switch key := key.(type) {
+	case Leader:
+		var outputs []string
+		key.Sequences.Walk(func(_ []input.Chord, key OutputEvent) error {
+			outputs = append(outputs, typedOutputs(key)...)
+			return nil
+		})
+		return outputs
+	case TapHold:
+		return typedOutputs(key.Tap)
+	case BrailleCell:
+		r := brailleReader{}
+		return []string{describeOutput(Text(r.cell(key.Dots)))}
+	default:
+	}
+	return []string{describeOutput(key)}
+}
+
+// onePerPad reports whether each chord is played on a single pad
+// and no two of them on the same one. An output bound like this is
+// there so that either thumb can play it.
+func onePerPad(chords []input.Chord) bool {
+	used := input.Chord(0)
+	for _, chord := range chords {
+		pad := input.Chord(0)
+		for _, offset := range padOffsets {
+			if chord&(PAD_ALL<<offset) == chord {
+				pad = PAD_ALL << offset
+			}
+		}
+		if pad == 0 || used&pad != 0 {
+			return false
+		}
+		used |= pad
+	}
+	return true
+}
+
+// CheckLayout reports chords that are bound to the same output,
+// unless there is one on each pad, chords a steamController can't
+// produce or can only produce by sliding a thumb across zones,
+// chords that include modifier bits and letters that nothing types.
+func CheckLayout(layout Layout) []LayoutProblem {
+	var problems []LayoutProblem
+
+	chords := layout.Chords()
+
+	outputs := make(map[string][]input.Chord)
+	var outputOrder []string
+	typed := make(map[string]bool)
+	for _, chord := range chords {
+		output := lintOutput(layout[chord])
+		if _, exists := outputs[output]; !exists {
+			outputOrder = append(outputOrder, output)
+		}
+		outputs[output] = append(outputs[output], chord)
+
+		for _, output := range typedOutputs(layout[chord]) {
+			typed[output] = true
+		}
+	}
+
+	for _, output := range outputOrder {
+		if dups := outputs[output]; len(dups) > 1 && !onePerPad(dups) {
+			problems = append(problems, LayoutProblem{"duplicate", dups,
+				fmt.Sprintf("chords %s all output %s", formatChords(dups), output)})
+		}
+	}
+
+	producible := producibleBits()
+	for _, chord := range chords {
+		if chord&MOD_ALL != 0 {
+			problems = append(problems, LayoutProblem{"modifier", []input.Chord{chord},
+				fmt.Sprintf("chord %d includes modifier bits %d and can never be played", chord, chord&MOD_ALL)})
+			continue
+		}
+
+		if missing := chord &^ producible; missing != 0 {
+			problems = append(problems, LayoutProblem{"unreachable", []input.Chord{chord},
+				fmt.Sprintf("chord %d uses bits %d that no input produces", chord, missing)})
+			continue
+		}
+
+		for i, zones := range padZones(chord) {
+			if zones > 1 {
+				problems = append(problems, LayoutProblem{"slide", []input.Chord{chord},
+					fmt.Sprintf("chord %d needs %d zones of the %s pad and can only be played by sliding", chord, zones, padNames[i])})
+			}
+		}
+	}
+
+	for i, key := range alphabet {
+		if !typed[describeOutput(Letter(key))] {
+			problems = append(problems, LayoutProblem{"missing", nil,
+				fmt.Sprintf("letter %c has no binding", 'A'+i)})
+		}
+	}
+
+	return problems
+}
+
+// checkLayout prints the problems found in the layout and returns
+// an error if there were any that aren't notes.
+func checkLayout(args []string) error {
+	flags := flag.NewFlagSet("check-layout", flag.ExitOnError)
+	strict := flags.Bool("strict", false, "treat chords that need sliding across zones as problems")
+	selectedLayout := layoutFlag(flags)
+	flags.Parse(args)
+
+	layout, err := selectedLayout()
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, problem := range CheckLayout(layout) {
+		fmt.Fprintln(os.Stdout, problem)
+		if *strict || !problem.IsNote() {
+			count++
+		}
+	}
+
+	if count > 0 {
+		return fmt.Errorf("layout has %d problems", count)
+	}
+	return nil
+}
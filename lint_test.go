@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghthor/chordpad/input"
+	"github.com/ghthor/uinput"
+)
+
+func TestLayoutsPassCheckLayout(t *testing.T) {
+	for _, name := range LayoutNames() {
+		for _, problem := range CheckLayout(Layouts[name]) {
+			if !problem.IsNote() {
+				t.Errorf("layout %s: %s", name, problem)
+			}
+		}
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	leader := func(chord input.Chord, key OutputEvent) Leader {
+		l := NewLeader()
+		l.Sequences.Add([]input.Chord{chord}, key)
+		return l
+	}
+
+	cases := []struct {
+		name   string
+		layout Layout
+		want   string
+	}{
+		{"an output on each pad isn't a duplicate",
+			Layout{1 << PAD_N: Func(FN_SPACE), 1 << (PAD_N + 4): Func(FN_SPACE)}, ""},
+		{"an output twice on one pad is a duplicate",
+			Layout{1 << PAD_N: Func(FN_SPACE), 1 << PAD_S: Func(FN_SPACE)}, "duplicate"},
+		{"leaders with different sequences aren't duplicates",
+			Layout{1 << PAD_N: leader(1<<PAD_S, Letter(uinput.KEY_Q)), 1 << PAD_S: leader(1<<PAD_S, Letter(uinput.KEY_X))}, ""},
+		{"leaders with the same sequences are duplicates",
+			Layout{1 << PAD_N: leader(1<<PAD_S, Letter(uinput.KEY_Q)), 1 << PAD_S: leader(1<<PAD_S, Letter(uinput.KEY_Q))}, "duplicate"},
+		{"a tap/hold chord isn't a duplicate of its tap",
+			Layout{1 << PAD_N: Func(FN_SPACE), 1 << PAD_S: TapHold{Func(FN_SPACE), uinput.KEY_LEFTSHIFT}}, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ""
+			for _, problem := range CheckLayout(c.layout) {
+				if problem.Kind != "missing" {
+					got = problem.Kind
+				}
+			}
+			if got != c.want {
+				t.Errorf("got problem %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCheckLayoutFindsTypedLetters(t *testing.T) {
+	leader := NewLeader()
+	leader.Sequences.Add([]input.Chord{1 << PAD_S}, Letter(uinput.KEY_B))
+
+	layout := Layout{
+		1 << PAD_N: BrailleCell{dots(1), 2},
+		1 << PAD_S: leader,
+		1 << PAD_E: TapHold{Letter(uinput.KEY_C), uinput.KEY_LEFTSHIFT},
+	}
+
+	missing := make(map[string]bool)
+	for _, problem := range CheckLayout(layout) {
+		if problem.Kind == "missing" {
+			missing[problem.Message] = true
+		}
+	}
+
+	for _, letter := range "ABC" {
+		if message := fmt.Sprintf("letter %c has no binding", letter); missing[message] {
+			t.Errorf("%s, but the layout types it", message)
+		}
+	}
+	if len(missing) != 23 {
+		t.Errorf("%d letters are missing, want 23", len(missing))
+	}
+}
@@ -2,40 +2,33 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/ghthor/chordpad/input"
-	"github.com/ghthor/uinput"
+	evdev "github.com/ghthor/golang-evdev"
 )
 
-func send(ctx context.Context, device *input.Source, output uinput.VKeyboard) error {
+// A Stage observes or transforms the chord events produced by a
+// Source before they are played.
+type Stage func(context.Context, <-chan input.Event) <-chan input.Event
+
+func send(ctx context.Context, device *input.Source, player *Player, stages ...Stage) error {
 	ctx, cancelCtx := context.WithCancel(ctx)
 	defer cancelCtx()
-	return apply(device.FlatMapModelChanges(ctx), &output)
-}
-
-func apply(changes <-chan input.Model, device *uinput.VKeyboard) error {
-	for model := range changes {
-		if model.Trigger == 0 {
-			continue
-		}
-
-		if key, isBound := Chords[model.Trigger&^MOD_ALL]; isBound {
-			if model.Trigger&MOD_ALL != 0 {
-				key = applyModifiersTo(key, model.Trigger&MOD_ALL)
-			}
-
-			if err := key.OutputTo(device); err != nil {
-				return err
-			}
-
-			continue
-		}
 
-		log.Println("unbound chord", model.Trigger)
+	events := device.Events(ctx)
+	for _, stage := range stages {
+		events = stage(ctx, events)
 	}
 
-	return nil
+	return player.Play(events)
 }
 
 // Must is used to specify any error returned as a fatal error
@@ -47,41 +40,245 @@ func Must(err error) {
 }
 
 func main() {
-	// TODO: Provide flag to specify the evdev device used to produce chords
-	// TODO: Enable using multiple evdev devices to power chord production
-	// TODO: Provide flag to specify the uinput device file path
-	// TODO: Provide flag for a config file path
-	// TODO: Support configuration file
+	Must(runCommand(os.Args[1:]))
+}
+
+// firstError returns err unless it is nil, otherwise next.
+func firstError(err, next error) error {
+	if err != nil {
+		return err
+	}
+	return next
+}
+
+// A daemon is the state of the run command that outlives each input
+// device that is linked to its virtual keyboard.
+type daemon struct {
+	keyboard *VirtualKeyboard
+	control  *Control
+	stats    *Stats
+	metrics  *Metrics
+
+	// Set to 1 while a device is linked, when the heartbeats of the
+	// systemd watchdog are answered by the Player instead of run.
+	linked    int32
+	heartbeat chan struct{}
+}
 
-searchForInputDevice:
-	log.Println("auto selecting chord input device")
-	// Can trigger os.Exit()
-	dev := autoSelectEvdevDevice()
+// link sends the chords played on an evdev device to the uinput
+// virtual keyboard until the device fails or ctx is done. Any keys
+// still held down are released before link returns.
+func (d *daemon) link(ctx context.Context, device *evdev.InputDevice) error {
+	// A reloaded config applies from the next device that is linked
+	config := d.control.Config()
 
 	log.Println("input device found")
-	log.Println(dev)
+	log.Println(device)
+	d.metrics.DeviceConnects.Inc()
+	d.control.Attach(device.Fn, device.Name)
+	defer d.control.Detach(device.Fn)
 
-	log.Println("creating uinput virtual keyboard output device")
-	vk := uinput.VKeyboard{Name: "Test Chordpad Device"}
-	Must(vk.Create("/dev/uinput"))
+	// TODO: Map into different types of devices
+	events, err := newEvdevSource(device)
+	if err != nil {
+		return err
+	}
+
+	dev := newSteamController(countingSource{events, &d.metrics.EventsRead})
+	defer dev.Close()
 
+	delay, period, err := events.RepeatRate()
+	if err != nil {
+		log.Println("reading repeat rate:", err)
+	}
+	repeat := config.AutoRepeat(delay, period)
+
+	notify(fmt.Sprintf("STATUS=linked to %s (%s)", device.Name, device.Fn))
 	log.Println("linking evdev input device to uinput virtual keyboard")
 
-	source := input.Source{dev, nil}
-	err := send(context.Background(), &source, vk)
+	held := NewHeldKeys(d.keyboard)
+	output := NewPacedSink(held, config.KeyInterval.Duration, DefaultFrameQueue)
+	output.Latency = d.metrics.Latency.ObserveDuration
+
+	source := input.Source{
+		Device: countingDevice{dev, &d.metrics.ModelChanges},
+		Timing: config.Timing(),
+	}
+	player := &Player{
+		Keymap:          d.control,
+		Output:          output,
+		Repeat:          repeat,
+		HoldThreshold:   config.HoldThreshold.Duration,
+		SequenceTimeout: config.SequenceTimeout.Duration,
+		BrailleTimeout:  config.BrailleTimeout.Duration,
+		Heartbeat:       d.heartbeat,
+		Alive:           func() { notify("WATCHDOG=1") },
+	}
+
+	atomic.StoreInt32(&d.linked, 1)
+	defer atomic.StoreInt32(&d.linked, 0)
+
+	err = send(ctx, &source, player,
+		d.control.Tap(), d.stats.Tap(d.control, config.Stats), d.metrics.Tap(d.control))
 	if err != nil {
-		log.Println(err)
+		d.metrics.OutputErrors.Inc()
 	}
 
-	log.Println("closing uinput virtual keyboard")
-	err = vk.Close()
+	err = firstError(err, source.Err())
+	err = firstError(err, output.Close())
+	return firstError(err, held.ReleaseAll())
+}
+
+// run links an evdev input device to a uinput virtual keyboard.
+// If the input device fails another will be searched for. SIGINT
+// and SIGTERM stop run after the virtual keyboard is closed and
+// SIGHUP reloads the config. The error of the last device that
+// failed is returned when run is stopped while waiting for another,
+// and failing to create the virtual keyboard is fatal.
+func run(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	configFlags := newConfigFlags(flags)
+	flags.Parse(args)
+
+	config, err := configFlags.Config()
 	if err != nil {
-		log.Println(err)
+		return err
 	}
 
-	if source.Err != nil {
-		log.Println(source.Err)
+	layout, err := LookupLayout(config.Layout)
+	if err != nil {
+		return err
 	}
 
-	goto searchForInputDevice
+	d := daemon{
+		stats:     NewStats(),
+		metrics:   NewMetrics(),
+		heartbeat: make(chan struct{}, 1),
+	}
+	if config.Stats != "" {
+		d.stats, err = LoadStats(config.Stats)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.control = NewControl(config, layout)
+	d.control.Reload = configFlags.Config
+	if config.Control != "" {
+		l, err := d.control.ListenAndServe(config.Control)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+	}
+
+	if config.Metrics != "" {
+		err := d.metrics.ListenAndServe(config.Metrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The virtual keyboard outlives the input devices so it can't be
+	// replaced by a reload, and chords can't be played without it
+	log.Println("creating uinput virtual keyboard output device")
+	d.keyboard, err = NewVirtualKeyboard(config.Uinput, "Test Chordpad Device")
+	if err != nil {
+		return fmt.Errorf("creating uinput virtual keyboard: %v", err)
+	}
+	defer func() {
+		log.Println("closing uinput virtual keyboard")
+		if err := d.keyboard.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	// chordpad is ready once it can play chords, even if it has to
+	// wait for an input device to be plugged in
+	notify("READY=1")
+
+	// The systemd watchdog is pinged by the Player while a device is
+	// linked, so that a stuck link is noticed, and otherwise from the
+	// same loop that handles SIGHUP so a stuck reload is noticed.
+	var watchdog <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	stopped := make(chan struct{})
+	defer func() {
+		stop()
+		<-stopped
+	}()
+
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-hangup:
+				log.Println("reloading config")
+				if err := d.control.ReloadConfig(); err != nil {
+					log.Println("reload:", err)
+				}
+			case <-watchdog:
+				if atomic.LoadInt32(&d.linked) == 0 {
+					notify("WATCHDOG=1")
+					continue
+				}
+
+				// A Player that hasn't taken the last heartbeat is behind
+				// and isn't sent another
+				select {
+				case d.heartbeat <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				notify("STOPPING=1")
+				return
+			}
+		}
+	}()
+
+	// The error of the last device that failed
+	var linkErr error
+
+	// TODO: Enable using multiple evdev devices to power chord production
+	for {
+		path := d.control.Config().Device
+		if path == "" {
+			log.Println("auto selecting chord input device")
+			notify("STATUS=waiting for a supported chord input device to be plugged in")
+		} else {
+			log.Println("waiting for chord input device", path)
+			notify("STATUS=waiting for chord input device " + path)
+		}
+
+		device, err := waitForEvdevDevice(ctx, path)
+		if ctx.Err() != nil {
+			log.Println("shutting down")
+			return linkErr
+		}
+		if err != nil {
+			return err
+		}
+
+		err = d.link(ctx, device)
+		if ctx.Err() != nil {
+			log.Println("shutting down")
+			return err
+		}
+
+		if err != nil {
+			log.Println(err)
+		}
+		linkErr = err
+	}
 }
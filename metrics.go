@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+	evdev "github.com/ghthor/golang-evdev"
+)
+
+// A Counter is a monotonically increasing metric.
+type Counter struct {
+	value uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// A Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	// Upper bounds of each bucket in ascending order.
+	Buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(buckets ...float64) *Histogram {
+	return &Histogram{Buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.Buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// ObserveDuration observes a duration in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Metrics are the counters and histograms exposed by the metrics
+// endpoint of a running chordpad.
+type Metrics struct {
+	EventsRead     Counter
+	ModelChanges   Counter
+	ChordEvents    Counter
+	Triggers       Counter
+	Cancelled      Counter
+	UnboundChords  Counter
+	OutputErrors   Counter
+	DeviceConnects Counter
+
+	// Time from the evdev timestamp of the input event that caused
+	// a trigger to its output being written to uinput.
+	Latency *Histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Latency: NewHistogram(.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1),
+	}
+}
+
+// A countingSource counts the events read from an eventSource.
+type countingSource struct {
+	eventSource
+	read *Counter
+}
+
+func (src countingSource) Unwrap() eventSource {
+	return src.eventSource
+}
+
+func (src countingSource) ReadOne(ctx context.Context) (*evdev.InputEvent, error) {
+	e, err := src.eventSource.ReadOne(ctx)
+	if err == nil {
+		src.read.Inc()
+	}
+	return e, err
+}
+
+// A countingDevice counts the changes of the model of an
+// input.Device.
+type countingDevice struct {
+	input.Device
+	changes *Counter
+}
+
+func (dev countingDevice) Update(ctx context.Context, m input.Model) (input.Model, error) {
+	next, err := dev.Device.Update(ctx, m)
+	if err == nil && !next.Equal(m) {
+		dev.changes.Inc()
+	}
+	return next, err
+}
+
+// Tap counts the events and the triggered, cancelled and unbound
+// chords of a channel of chord events and passes every event through
+// unchanged.
+func (m *Metrics) Tap(keymap Keymap) Stage {
+	return func(ctx context.Context, events <-chan input.Event) <-chan input.Event {
+		output := make(chan input.Event)
+
+		go func() {
+			defer close(output)
+
+			for e := range events {
+				m.ChordEvents.Inc()
+				switch e := e.(type) {
+				case input.ChordCommitted:
+					m.Triggers.Inc()
+					if _, isBound := keymap.Lookup(e.Chord); !isBound {
+						m.UnboundChords.Inc()
+					}
+				case input.ChordCancelled:
+					m.Cancelled.Inc()
+				default:
+				}
+
+				select {
+				case output <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		return output
+	}
+}
+
+func writeCounter(b *bytes.Buffer, name, help string, c *Counter) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+	fmt.Fprintf(b, "%s %d\n", name, c.Value())
+}
+
+func writeHistogram(b *bytes.Buffer, name, help string, h *Histogram) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+	for i, bound := range h.Buckets {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		fmt.Fprintf(b, "%s_bucket{le=%q} %d\n", name, le, h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(b, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(b, "%s_count %d\n", name, h.count)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b := bytes.Buffer{}
+	writeCounter(&b, "chordpad_evdev_events_total", "Input events read from evdev devices.", &m.EventsRead)
+	writeCounter(&b, "chordpad_model_changes_total", "Changes of the chord input model.", &m.ModelChanges)
+	writeCounter(&b, "chordpad_chord_events_total", "Key and chord lifecycle events.", &m.ChordEvents)
+	writeCounter(&b, "chordpad_triggers_total", "Chords that were played.", &m.Triggers)
+	writeCounter(&b, "chordpad_cancelled_chords_total", "Chords abandoned without being played.", &m.Cancelled)
+	writeCounter(&b, "chordpad_unbound_chords_total", "Chords played that have no binding.", &m.UnboundChords)
+	writeCounter(&b, "chordpad_output_errors_total", "Errors writing to the virtual keyboard.", &m.OutputErrors)
+	writeCounter(&b, "chordpad_device_connects_total", "Times an input device was connected.", &m.DeviceConnects)
+	writeHistogram(&b, "chordpad_latency_seconds",
+		"Time from an input event to the output it caused being written.", m.Latency)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+// ListenAndServe serves the metrics at /metrics on addr. Only
+// loopback addresses are accepted because the endpoint has no
+// authentication.
+func (m *Metrics) ListenAndServe(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("metrics address %q must be on localhost", addr)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+
+	log.Println("serving metrics on", "http://"+l.Addr().String()+"/metrics")
+	go func() {
+		log.Println(http.Serve(l, mux))
+	}()
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// A chordBit names a single bit of a Chord.
+type chordBit struct {
+	input.Chord
+	Name string
+}
+
+var padBits = map[string]input.Chord{
+	"N": 1 << PAD_N,
+	"E": 1 << PAD_E,
+	"S": 1 << PAD_S,
+	"W": 1 << PAD_W,
+}
+
+var buttonBits = []chordBit{
+	{BTN_TL1, "TL1"},
+	{BTN_TL0, "TL0"},
+	{BTN_THUMBL, "THUMBL"},
+	{BTN_A, "A"},
+	{BTN_B, "B"},
+	{BTN_THUMBR, "THUMBR"},
+	{BTN_TR0, "TR0"},
+	{BTN_TR1, "TR1"},
+}
+
+var modBits = []chordBit{
+	{MOD_SHIFT, "SHIFT"},
+	{MOD_CTRL, "CTRL"},
+	{MOD_ALT, "ALT"},
+	{MOD_META, "META"},
+}
+
+const (
+	ansiClear   = "\x1b[H\x1b[2J"
+	ansiReverse = "\x1b[7m"
+	ansiBold    = "\x1b[1;4m"
+	ansiReset   = "\x1b[0m"
+)
+
+// A monitorScreen draws the state of an input.Model to a terminal.
+type monitorScreen struct {
+	device string
+	layout Layout
+
+	model      input.Model
+	lastChord  input.Chord
+	lastOutput string
+
+	// Most recent chord lifecycle events, oldest first.
+	events []input.Event
+}
+
+// monitorEvents is the number of events shown by the monitor.
+const monitorEvents = 6
+
+// label highlights a chord bit that is held in reverse video and a
+// bit that is part of the chord being built, but no longer held,
+// in bold.
+func (s monitorScreen) label(bit input.Chord, name string) string {
+	switch {
+	case s.model.Keys&bit != 0:
+		return ansiReverse + name + ansiReset
+	case s.model.Build&bit != 0:
+		return ansiBold + name + ansiReset
+	default:
+	}
+	return name
+}
+
+func (s monitorScreen) pad(offset ChordIndex) []string {
+	zone := func(name string) string {
+		return s.label(padBits[name]<<offset, "["+name+"]")
+	}
+
+	return []string{
+		"    " + zone("N") + "    ",
+		zone("W") + "     " + zone("E"),
+		"    " + zone("S") + "    ",
+	}
+}
+
+func describeOutput(key OutputEvent) string {
+	// Keys that only act on a Player describe themselves
+	if s, isStringer := key.(fmt.Stringer); isStringer {
+		return s.String()
+	}
+
+	r := Recorder{}
+	if err := key.OutputTo(&r); err != nil {
+		return err.Error()
+	}
+
+	var events []string
+	for _, e := range r.Events() {
+		events = append(events, e.String())
+	}
+	return strings.Join(events, " ")
+}
+
+func describeChord(layout Layout, chord input.Chord) string {
+	if chord == 0 {
+		return "-"
+	}
+
+	if key, isBound := layout.Lookup(chord); isBound {
+		return fmt.Sprintf("%d => %s", chord, describeOutput(key))
+	}
+	return fmt.Sprintf("%d => unbound", chord)
+}
+
+func (s monitorScreen) draw(w io.Writer) {
+	b := bytes.Buffer{}
+	b.WriteString(ansiClear)
+	fmt.Fprintf(&b, "chordpad monitor: %s\n\n", s.device)
+
+	fmt.Fprintf(&b, "  %-17s  %s\n", "left pad", "right pad")
+	left, right := s.pad(0), s.pad(4)
+	for i := range left {
+		fmt.Fprintf(&b, "  %s      %s\n", left[i], right[i])
+	}
+	b.WriteString("\n ")
+
+	for _, bit := range buttonBits {
+		b.WriteString(" " + s.label(bit.Chord, bit.Name))
+	}
+	b.WriteString("\n ")
+	for _, bit := range modBits {
+		b.WriteString(" " + s.label(bit.Chord, bit.Name))
+	}
+	b.WriteString("\n\n")
+
+	fmt.Fprintf(&b, "  keys      %d\n", s.model.Keys)
+	fmt.Fprintf(&b, "  building  %s\n", describeChord(s.layout, s.model.Build))
+	if s.lastChord != 0 {
+		fmt.Fprintf(&b, "  last      %d => %s\n", s.lastChord, s.lastOutput)
+	} else {
+		fmt.Fprintf(&b, "  last      -\n")
+	}
+
+	b.WriteString("\n  events\n")
+	for _, e := range s.events {
+		fmt.Fprintf(&b, "    %s  %v\n", e.When().Format("15:04:05.000"), e)
+	}
+
+	w.Write(b.Bytes())
+}
+
+// Update applies an event to the model that is drawn.
+func (s *monitorScreen) Update(e input.Event) {
+	s.model = s.model.Apply(e)
+
+	s.events = append(s.events, e)
+	if len(s.events) > monitorEvents {
+		s.events = s.events[len(s.events)-monitorEvents:]
+	}
+
+	if committed, isCommit := e.(input.ChordCommitted); isCommit {
+		s.lastChord = committed.Chord
+		if key, isBound := s.layout.Lookup(committed.Chord); isBound {
+			s.lastOutput = describeOutput(key)
+		} else {
+			s.lastOutput = "unbound"
+		}
+	}
+}
+
+// monitor draws the pads and the chord being built in the terminal
+// while a device is used. No output is sent to a virtual keyboard.
+func monitor(args []string) error {
+	flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+	devicePath := flags.String("device", "", "evdev device to monitor, auto selected if empty")
+	replayPath := flags.String("replay", "", "monitor a recording instead of a device")
+	selectedLayout := layoutFlag(flags)
+	flags.Parse(args)
+
+	layout, err := selectedLayout()
+	if err != nil {
+		return err
+	}
+
+	events, err := openEventSource(*devicePath, *replayPath)
+	if err != nil {
+		return err
+	}
+
+	dev := newSteamController(events)
+	defer dev.Close()
+
+	screen := monitorScreen{
+		device: strings.SplitN(dev.String(), "\n", 2)[0],
+		layout: layout,
+	}
+	screen.draw(os.Stdout)
+
+	source := input.Source{Device: dev}
+	for e := range source.Events(context.Background()) {
+		screen.Update(e)
+		screen.draw(os.Stdout)
+	}
+
+	if err := source.Err(); err != io.EOF {
+		return err
+	}
+	return nil
+}
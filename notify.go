@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state change, e.g. "READY=1", to the systemd
+// service manager. It does nothing if chordpad isn't run as a
+// systemd service of Type=notify.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+
+	// Abstract sockets are given with a leading @.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notify logs the error of sdNotify because the service manager
+// being unreachable shouldn't stop chords from being played.
+func notify(state string) {
+	if err := sdNotify(state); err != nil {
+		log.Println("sd_notify:", err)
+	}
+}
+
+// watchdogInterval returns how often the systemd watchdog must be
+// pinged, half of WatchdogSec, or 0 if the watchdog isn't enabled.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ghthor/chordpad/input"
+	"github.com/ghthor/uinput"
+)
+
+// corpusKeys are the characters of a corpus that the optimizer will
+// find a chord for. Upper case letters are counted as lower case
+// because the shift modifier is applied by a button.
+var corpusKeys = func() map[rune]OutputEvent {
+	keys := map[rune]OutputEvent{
+		' ':  Func(FN_SPACE),
+		'\n': Func(FN_ENTER),
+		'\t': Func(FN_TAB),
+		',':  Letter(uinput.KEY_COMMA),
+		'.':  Letter(uinput.KEY_DOT),
+		'\'': Letter(uinput.KEY_APOSTROPHE),
+		';':  Letter(uinput.KEY_SEMICOLON),
+		'-':  Letter(uinput.KEY_MINUS),
+		'/':  Letter(uinput.KEY_SLASH),
+	}
+
+	for i, key := range alphabet {
+		keys[rune('a'+i)] = Letter(key)
+	}
+	return keys
+}()
+
+// ChordEffort is the cost of each part of playing a chord used to
+// score a layout. A lower effort is easier to play.
+type ChordEffort struct {
+	// Cost of each pad that is touched.
+	Pad float64
+
+	// Cost of sliding into a zone next to a zone already touched.
+	AdjacentSlide float64
+
+	// Cost of sliding across the pad into the opposite zone.
+	OppositeSlide float64
+
+	// Cost of each button or trigger that is held.
+	Modifier float64
+}
+
+// DefaultChordEffort prefers chords that use a single zone of one
+// pad, then a zone of each pad, then slides.
+var DefaultChordEffort = ChordEffort{
+	Pad:           1,
+	AdjacentSlide: 1.5,
+	OppositeSlide: 2.5,
+	Modifier:      1,
+}
+
+// oppositeZones are the pairs of zones on opposite sides of a pad.
+var oppositeZones = []input.Chord{
+	1<<PAD_N | 1<<PAD_S,
+	1<<PAD_E | 1<<PAD_W,
+}
+
+// Of returns the effort of playing a chord.
+func (e ChordEffort) Of(chord input.Chord) float64 {
+	effort := 0.0
+	for _, offset := range padOffsets {
+		zones := (chord >> offset) & PAD_ALL
+		if zones == 0 {
+			continue
+		}
+		effort += e.Pad
+
+		count := 0
+		for bits := zones; bits != 0; bits &= bits - 1 {
+			count++
+		}
+
+		// Every zone after the first is reached by a slide, which
+		// crosses the pad if the zones are opposite each other.
+		slides := count - 1
+		for _, opposite := range oppositeZones {
+			if zones&opposite == opposite && slides > 0 {
+				effort += e.OppositeSlide
+				slides--
+			}
+		}
+		effort += float64(slides) * e.AdjacentSlide
+	}
+
+	for bits := chord &^ (PAD_ALL | PAD_ALL<<4); bits != 0; bits &= bits - 1 {
+		effort += e.Modifier
+	}
+
+	return effort
+}
+
+// ChordSpace returns every chord the AbsPad zones can produce using
+// at most maxZones zones of each pad, optionally combined with one of
+// the extra bits, e.g. the triggers.
+func ChordSpace(maxZones int, extra []input.Chord) []input.Chord {
+	var padChords []input.Chord
+	for chord := input.Chord(1); chord <= PAD_ALL|PAD_ALL<<4; chord++ {
+		fits := true
+		for _, zones := range padZones(chord) {
+			if zones > maxZones {
+				fits = false
+			}
+		}
+
+		if fits {
+			padChords = append(padChords, chord)
+		}
+	}
+
+	chords := append([]input.Chord(nil), padChords...)
+	for _, bit := range extra {
+		for _, chord := range padChords {
+			chords = append(chords, chord|bit)
+		}
+	}
+
+	return chords
+}
+
+// A CharCount is the number of times a character appears in a corpus.
+type CharCount struct {
+	Char  rune
+	Count int
+}
+
+// CountCorpus counts the characters in a corpus that have a key in
+// corpusKeys and returns them from most to least frequent.
+func CountCorpus(r io.Reader) ([]CharCount, error) {
+	counts := make(map[rune]int)
+
+	br := bufio.NewReader(r)
+	for {
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c = unicode.ToLower(c)
+		if _, exists := corpusKeys[c]; exists {
+			counts[c]++
+		}
+	}
+
+	var freq []CharCount
+	for c, count := range counts {
+		freq = append(freq, CharCount{c, count})
+	}
+	sort.Slice(freq, func(i, j int) bool {
+		if freq[i].Count == freq[j].Count {
+			return freq[i].Char < freq[j].Char
+		}
+		return freq[i].Count > freq[j].Count
+	})
+
+	return freq, nil
+}
+
+func openCorpus(paths []string) (io.Reader, func(), error) {
+	var readers []io.Reader
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+
+		files = append(files, f)
+		readers = append(readers, f)
+	}
+
+	return io.MultiReader(readers...), closeAll, nil
+}
+
+// ScoreLayout returns the average effort per character of typing a
+// corpus with a layout and the number of characters that have no
+// binding in the layout.
+func ScoreLayout(layout Layout, freq []CharCount, effort ChordEffort) (score float64, unbound int) {
+	best := make(map[string]float64)
+	for chord, key := range layout {
+		name := describeOutput(key)
+		if e, exists := best[name]; !exists || effort.Of(chord) < e {
+			best[name] = effort.Of(chord)
+		}
+	}
+
+	total := 0
+	for _, c := range freq {
+		e, exists := best[describeOutput(corpusKeys[c.Char])]
+		if !exists {
+			unbound += c.Count
+			continue
+		}
+		score += e * float64(c.Count)
+		total += c.Count
+	}
+
+	if total > 0 {
+		score /= float64(total)
+	}
+	return score, unbound
+}
+
+// OptimizeLayout assigns the easiest chords to the most frequent
+// characters. Because the score of a layout is the sum of each
+// character's frequency times the effort of its chord, pairing
+// the sorted frequencies with the sorted efforts is optimal.
+func OptimizeLayout(freq []CharCount, extra []OutputEvent, chords []input.Chord, effort ChordEffort) (Layout, error) {
+	if len(freq)+len(extra) > len(chords) {
+		return nil, fmt.Errorf("%d characters need a chord but only %d chords are available",
+			len(freq)+len(extra), len(chords))
+	}
+
+	chords = append([]input.Chord(nil), chords...)
+	sort.SliceStable(chords, func(i, j int) bool {
+		ei, ej := effort.Of(chords[i]), effort.Of(chords[j])
+		if ei == ej {
+			return chords[i] < chords[j]
+		}
+		return ei < ej
+	})
+
+	layout := make(Layout, len(freq)+len(extra))
+	for i, c := range freq {
+		layout[chords[i]] = corpusKeys[c.Char]
+	}
+
+	// Keys such as backspace are never in a corpus so they are
+	// given the easiest chords that remain.
+	for i, key := range extra {
+		layout[chords[len(freq)+i]] = key
+	}
+
+	return layout, nil
+}
+
+// optimize reads a corpus and writes the layout that minimizes the
+// effort of typing it.
+func optimize(args []string) error {
+	flags := flag.NewFlagSet("optimize", flag.ExitOnError)
+	format := flags.String("format", "json", "output format, go or json")
+	outputPath := flags.String("o", "", "file to write the layout into, stdout if empty")
+	varName := flags.String("var", "Chords", "name of the Layout variable in go output")
+	maxZones := flags.Int("max-zones", 2, "maximum number of zones of a pad used in a chord")
+	triggers := flags.Bool("triggers", false, "include chords that hold a trigger")
+	extraKeys := flags.String("extra", "KEY_BACKSPACE,KEY_ESC", "comma separated keys to bind that aren't in the corpus")
+	selectedLayout := layoutFlag(flags)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return errors.New("optimize requires a corpus file")
+	}
+
+	corpus, closeCorpus, err := openCorpus(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	freq, err := CountCorpus(corpus)
+	closeCorpus()
+	if err != nil {
+		return err
+	}
+
+	var extra []OutputEvent
+	for _, name := range strings.Split(*extraKeys, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		key, err := parseKey(name)
+		if err != nil {
+			return err
+		}
+		extra = append(extra, key)
+	}
+
+	var extraBits []input.Chord
+	if *triggers {
+		for _, trigger := range newTriggers() {
+			extraBits = append(extraBits, trigger.output)
+		}
+		sortChords(extraBits)
+	}
+
+	effort := DefaultChordEffort
+	layout, err := OptimizeLayout(freq, extra, ChordSpace(*maxZones, extraBits), effort)
+	if err != nil {
+		return err
+	}
+
+	current, err := selectedLayout()
+	if err != nil {
+		return err
+	}
+
+	score, _ := ScoreLayout(layout, freq, effort)
+	currentScore, unbound := ScoreLayout(current, freq, effort)
+	fmt.Fprintf(os.Stderr, "optimized effort per character %.3f, current layout %.3f", score, currentScore)
+	if unbound > 0 {
+		fmt.Fprintf(os.Stderr, " with %d characters unbound", unbound)
+	}
+	fmt.Fprintln(os.Stderr)
+
+	var w io.Writer = os.Stdout
+	if *outputPath != "" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "json":
+		return WriteLayoutJSON(w, layout)
+	case "go":
+		comment := fmt.Sprintf("%s was generated by `chordpad optimize` from %s.",
+			*varName, strings.Join(flags.Args(), ", "))
+		return WriteLayoutGo(w, *varName, comment, layout)
+	default:
+	}
+
+	return fmt.Errorf("unknown format %q", *format)
+}
@@ -16,9 +16,36 @@ const (
 	FN_ESCAPE           = uinput.KEY_ESC
 )
 
-// An OutputEvent is used to send virtual input events using a uinput device.
+// An OutputEvent is used to send virtual input events to a KeySink.
 type OutputEvent interface {
-	OutputTo(*uinput.VKeyboard) error
+	OutputTo(KeySink) error
+}
+
+// A HoldableEvent is an OutputEvent whose keys can be pressed and
+// held down until they are released.
+type HoldableEvent interface {
+	OutputEvent
+	Press(KeySink) error
+	Release(KeySink) error
+}
+
+// holdable returns the HoldableEvent of a key if every key it
+// outputs can be held.
+func holdable(key OutputEvent) (HoldableEvent, bool) {
+	switch key := key.(type) {
+	case Wrap:
+		if _, isHoldable := holdable(key.OutputEvent); !isHoldable {
+			return nil, false
+		}
+	case ShiftPlus:
+		if _, isHoldable := holdable(key.OutputEvent); !isHoldable {
+			return nil, false
+		}
+	default:
+	}
+
+	h, isHoldable := key.(HoldableEvent)
+	return h, isHoldable
 }
 
 type singleKeyPress int
@@ -33,46 +60,134 @@ type Letter int
 type Func Action
 type Num int
 
-func (key singleKeyPress) OutputTo(vk *uinput.VKeyboard) error {
-	err := vk.SendKeyPress(int(key))
+func (key singleKeyPress) OutputTo(sink KeySink) error {
+	err := sink.KeyPress(int(key))
 	if err != nil {
 		return err
 	}
 
-	return vk.SendKeyRelease(int(key))
+	// The press and release must be reported in separate frames or
+	// an application may never see the key as being held down.
+	err = sink.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = sink.KeyRelease(int(key))
+	if err != nil {
+		return err
+	}
+
+	return sink.Sync()
 }
 
-func (key ShiftPlus) OutputTo(vk *uinput.VKeyboard) error {
-	return Wrap{key.OutputEvent, uinput.KEY_RIGHTSHIFT}.OutputTo(vk)
+func (key singleKeyPress) Press(sink KeySink) error {
+	if err := sink.KeyPress(int(key)); err != nil {
+		return err
+	}
+	return sink.Sync()
 }
 
-func (key Wrap) OutputTo(vk *uinput.VKeyboard) error {
-	err := vk.SendKeyPress(int(key.mod))
+func (key singleKeyPress) Release(sink KeySink) error {
+	if err := sink.KeyRelease(int(key)); err != nil {
+		return err
+	}
+	return sink.Sync()
+}
+
+func (key ShiftPlus) OutputTo(sink KeySink) error {
+	return Wrap{key.OutputEvent, uinput.KEY_RIGHTSHIFT}.OutputTo(sink)
+}
+
+func (key Wrap) OutputTo(sink KeySink) error {
+	err := sink.KeyPress(int(key.mod))
 	if err != nil {
 		return err
 	}
 
-	err = key.OutputEvent.OutputTo(vk)
+	err = sink.Sync()
 	if err != nil {
 		return err
 	}
 
-	return vk.SendKeyRelease(int(key.mod))
+	err = key.OutputEvent.OutputTo(sink)
+	if err != nil {
+		return err
+	}
+
+	err = sink.KeyRelease(int(key.mod))
+	if err != nil {
+		return err
+	}
+
+	return sink.Sync()
+}
+
+func (key ShiftPlus) Press(sink KeySink) error {
+	return Wrap{key.OutputEvent, uinput.KEY_RIGHTSHIFT}.Press(sink)
+}
+
+func (key ShiftPlus) Release(sink KeySink) error {
+	return Wrap{key.OutputEvent, uinput.KEY_RIGHTSHIFT}.Release(sink)
+}
+
+// Press holds the modifier and the wrapped key, which must be a
+// HoldableEvent.
+func (key Wrap) Press(sink KeySink) error {
+	if err := singleKeyPress(key.mod).Press(sink); err != nil {
+		return err
+	}
+	return key.OutputEvent.(HoldableEvent).Press(sink)
 }
 
-func (key Letter) OutputTo(vk *uinput.VKeyboard) error {
-	return singleKeyPress(key).OutputTo(vk)
+func (key Wrap) Release(sink KeySink) error {
+	if err := key.OutputEvent.(HoldableEvent).Release(sink); err != nil {
+		return err
+	}
+	return singleKeyPress(key.mod).Release(sink)
+}
+
+// A TapHold is a chord with two roles. Released before the hold
+// threshold it plays Tap like any other chord. Held past it, the
+// modifier key Hold is held down through the output of the next
+// chord, e.g. space when tapped and shift when held.
+type TapHold struct {
+	Tap  OutputEvent
+	Hold int
 }
 
-func (key Func) OutputTo(vk *uinput.VKeyboard) error {
-	return singleKeyPress(key).OutputTo(vk)
+// OutputTo plays the Tap, the role of a TapHold outside of a Player.
+func (key TapHold) OutputTo(sink KeySink) error {
+	return key.Tap.OutputTo(sink)
 }
 
-func (key Num) OutputTo(vk *uinput.VKeyboard) error {
-	return singleKeyPress(key).OutputTo(vk)
+func (key Letter) OutputTo(sink KeySink) error {
+	return singleKeyPress(key).OutputTo(sink)
+}
+
+func (key Letter) Press(sink KeySink) error   { return singleKeyPress(key).Press(sink) }
+func (key Letter) Release(sink KeySink) error { return singleKeyPress(key).Release(sink) }
+
+func (key Func) OutputTo(sink KeySink) error {
+	return singleKeyPress(key).OutputTo(sink)
 }
 
+func (key Func) Press(sink KeySink) error   { return singleKeyPress(key).Press(sink) }
+func (key Func) Release(sink KeySink) error { return singleKeyPress(key).Release(sink) }
+
+func (key Num) OutputTo(sink KeySink) error {
+	return singleKeyPress(key).OutputTo(sink)
+}
+
+func (key Num) Press(sink KeySink) error   { return singleKeyPress(key).Press(sink) }
+func (key Num) Release(sink KeySink) error { return singleKeyPress(key).Release(sink) }
+
 func applyModifiersTo(key OutputEvent, mods input.Chord) OutputEvent {
+	if dual, isDual := key.(TapHold); isDual {
+		dual.Tap = applyModifiersTo(dual.Tap, mods)
+		return dual
+	}
+
 	switch {
 	case mods&MOD_SHIFT != 0:
 		return ShiftPlus{key}
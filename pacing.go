@@ -0,0 +1,177 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultKeyInterval is the minimum time between frames of key
+// events written by a PacedSink unless otherwise configured.
+const DefaultKeyInterval = 8 * time.Millisecond
+
+// DefaultFrameQueue is the number of frames a PacedSink will buffer
+// before Sync blocks the caller.
+const DefaultFrameQueue = 64
+
+// A PacedSink batches key events into frames that are terminated
+// by Sync and writes them to another KeySink with at least
+// Interval between each frame. Applications that drop events
+// arriving too quickly will see a burst of output, e.g. a long
+// macro, as a steady stream of key events instead.
+//
+// Frames are written from a separate goroutine. When the queue of
+// frames is full Sync blocks until there is room and the delay is
+// reported as backpressure.
+type PacedSink struct {
+	// Latency is called, if set, with the time between the input
+	// event that caused a frame and the frame being written.
+	Latency func(time.Duration)
+
+	interval time.Duration
+	output   KeySink
+
+	origin  time.Time
+	pending []KeyEvent
+	frames  chan pacedFrame
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+type pacedFrame struct {
+	events []KeyEvent
+	origin time.Time
+}
+
+// An originSink is told the time of the input event that caused
+// the key events it will receive next.
+type originSink interface {
+	SetOrigin(time.Time)
+}
+
+// NewPacedSink creates a PacedSink that writes into output at most
+// one frame every interval and buffers up to queue frames.
+func NewPacedSink(output KeySink, interval time.Duration, queue int) *PacedSink {
+	if queue < 1 {
+		queue = 1
+	}
+
+	s := &PacedSink{
+		interval: interval,
+		output:   output,
+		frames:   make(chan pacedFrame, queue),
+		done:     make(chan struct{}),
+	}
+
+	go s.writeFrames()
+	return s
+}
+
+func (s *PacedSink) writeFrames() {
+	defer close(s.done)
+
+	var last time.Time
+	for frame := range s.frames {
+		if wait := s.interval - time.Since(last); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		err := s.writeFrame(frame.events)
+		last = time.Now()
+		if s.Latency != nil && !frame.origin.IsZero() {
+			s.Latency(last.Sub(frame.origin))
+		}
+
+		if err != nil {
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *PacedSink) writeFrame(frame []KeyEvent) error {
+	for _, e := range frame {
+		var err error
+		if e.Down {
+			err = s.output.KeyPress(e.Key)
+		} else {
+			err = s.output.KeyRelease(e.Key)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.output.Sync()
+}
+
+// Err returns the first error encountered while writing a frame.
+func (s *PacedSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// SetOrigin sets the time of the input event that caused the frames
+// that are synced after it.
+func (s *PacedSink) SetOrigin(t time.Time) {
+	s.origin = t
+}
+
+func (s *PacedSink) KeyPress(key int) error {
+	s.pending = append(s.pending, KeyEvent{key, true})
+	return s.Err()
+}
+
+func (s *PacedSink) KeyRelease(key int) error {
+	s.pending = append(s.pending, KeyEvent{key, false})
+	return s.Err()
+}
+
+// Sync queues the pending key events as a frame. If the queue is
+// full Sync will block until the frame can be queued.
+func (s *PacedSink) Sync() error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	frame := pacedFrame{s.pending, s.origin}
+	s.pending = nil
+
+	select {
+	case s.frames <- frame:
+		return nil
+	default:
+	}
+
+	start := time.Now()
+	s.frames <- frame
+	log.Printf("output backpressure: waited %v with %d frames queued", time.Since(start), cap(s.frames))
+
+	return s.Err()
+}
+
+// Pending returns the number of frames that are queued and have
+// not yet been written.
+func (s *PacedSink) Pending() int {
+	return len(s.frames)
+}
+
+// Close waits for every queued frame to be written and returns the
+// first error encountered while writing. The PacedSink cannot be
+// used after it has been closed.
+func (s *PacedSink) Close() error {
+	close(s.frames)
+	<-s.done
+	return s.Err()
+}
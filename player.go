@@ -0,0 +1,470 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// The ways a chord that is held down can be repeated.
+const (
+	// RepeatOff plays a chord once when it is released.
+	RepeatOff = "off"
+
+	// RepeatOutput plays the output of a held chord again and again
+	// at the repeat interval.
+	RepeatOutput = "output"
+
+	// RepeatHold presses the keys of a held chord and keeps them down
+	// until the chord is released, which leaves the repeating to the
+	// key repeat of the desktop. Desktops repeat held keys themselves
+	// and ignore the repeats of the kernel, so once the keys are
+	// pressed after the Delay, the desktop's own delay and rate apply.
+	RepeatHold = "hold"
+)
+
+// Used when the input device doesn't report a repeat rate, the
+// same as the kernel default.
+const (
+	DefaultRepeatDelay    = 250 * time.Millisecond
+	DefaultRepeatInterval = 33 * time.Millisecond
+)
+
+// A Repeat configures how a chord that is held past Delay repeats.
+type Repeat struct {
+	Mode     string
+	Delay    time.Duration
+	Interval time.Duration
+}
+
+// DefaultRepeat doesn't repeat held chords, as a chord that is built
+// slowly is held long enough to repeat before it is finished. The
+// delay and interval are used if the mode is changed.
+var DefaultRepeat = Repeat{RepeatOff, DefaultRepeatDelay, DefaultRepeatInterval}
+
+// Enabled reports whether held chords are repeated at all.
+func (r Repeat) Enabled() bool {
+	return r.Mode == RepeatOutput || r.Mode == RepeatHold
+}
+
+func validRepeatMode(mode string) error {
+	switch mode {
+	case RepeatOff, RepeatOutput, RepeatHold:
+		return nil
+	default:
+	}
+	return fmt.Errorf("unknown repeat mode %q, expected %s, %s or %s", mode, RepeatOff, RepeatOutput, RepeatHold)
+}
+
+// A heldChord is a chord being repeated because it is held down.
+type heldChord struct {
+	chord input.Chord
+	key   OutputEvent
+
+	// The time of the next repeat, measured with the times of the
+	// input events.
+	next time.Time
+
+	// Set once the chord has repeated, so it isn't played again when
+	// it is committed, and once its keys are released.
+	repeated bool
+	released bool
+
+	// The key being held down by RepeatHold.
+	pressed HoldableEvent
+
+	// The entry of the history that counts the repeats.
+	played *played
+}
+
+// DefaultHoldThreshold is how long a TapHold chord is held before
+// it plays its Hold instead of its Tap.
+const DefaultHoldThreshold = 200 * time.Millisecond
+
+// A Player plays the chords of a channel of events on a KeySink.
+type Player struct {
+	Keymap Keymap
+	Output KeySink
+	Repeat Repeat
+
+	// A TapHold chord held for at least HoldThreshold plays its Hold.
+	// TapHold chords only tap if it is 0.
+	HoldThreshold time.Duration
+
+	// How long to wait for the next chord of a sequence before it
+	// times out.
+	SequenceTimeout time.Duration
+
+	// How long a Grade 2 braille word waits for its next cell before
+	// it is typed. Words wait for another key if it is 0.
+	BrailleTimeout time.Duration
+
+	// Each heartbeat is answered by calling Alive from the loop that
+	// plays the events, so a Player that is stuck stops answering.
+	Heartbeat <-chan struct{}
+	Alive     func()
+
+	// The chord keys that are down and the chord being repeated
+	// because it is held.
+	keys input.Chord
+	held *heldChord
+
+	// When the chord being built last changed and the modifiers of
+	// held TapHold chords waiting for the next chord.
+	changed time.Time
+	mods    []int
+
+	// The output of the last chords played, for Undo and Retry.
+	history []*played
+}
+
+// A Played event is the output of chords that were played by a
+// chordFilter, e.g. the key of a sequence or the text of a braille
+// word. It is played as it is instead of being looked up.
+type Played struct {
+	Chord input.Chord
+	Key   OutputEvent
+	Time  time.Time
+}
+
+func (e Played) When() time.Time { return e.Time }
+
+// A chordFilter turns the chord events sent to a Player into the
+// events played after it. Any events held back are sent on once
+// they are due or the events end.
+type chordFilter interface {
+	filter(e input.Event) []input.Event
+
+	// expire returns the events that are due at the time now and
+	// nextDue when the next of them will be.
+	expire(now time.Time) []input.Event
+	nextDue() (time.Time, bool)
+
+	// end returns the events still held back when the events end.
+	end(now time.Time) []input.Event
+}
+
+// Play plays each chord event until events is closed. The events
+// pass through the sequences of leader chords and then the braille
+// translation before they are played. A repeat or a sequence timeout
+// that is due is played when the event after it arrives or, if no
+// event arrives in time, by a timer.
+func (p *Player) Play(events <-chan input.Event) error {
+	filters := []chordFilter{
+		&sequencer{keymap: p.Keymap, timeout: p.SequenceTimeout},
+		&brailleWriter{keymap: p.Keymap, timeout: p.BrailleTimeout},
+	}
+
+	var timer *time.Timer
+	var due <-chan time.Time
+
+	// The time of the latest input event, repeat or timeout
+	var now, next time.Time
+
+	for {
+		var e input.Event
+		isEvent, ok := true, true
+
+		select {
+		case <-p.Heartbeat:
+			p.Alive()
+			continue
+		case e, ok = <-events:
+		case <-due:
+			// An event that is already waiting is played first, as the
+			// time of its input event may be before the repeat or
+			// timeout that is due
+			select {
+			case e, ok = <-events:
+			default:
+				isEvent = false
+			}
+		}
+
+		switch {
+		case !isEvent:
+			now = next
+			if err := p.catchUp(filters, now); err != nil {
+				return err
+			}
+		case !ok:
+			return firstError(p.end(filters, now), p.release())
+		default:
+			now = e.When()
+			if err := p.catchUp(filters, now); err != nil {
+				return err
+			}
+			if err := p.feed(filters, e); err != nil {
+				return err
+			}
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, due = nil, nil
+
+		var isDue bool
+		if next, isDue = p.nextDue(filters); isDue {
+			timer = time.NewTimer(next.Sub(now))
+			due = timer.C
+		}
+	}
+}
+
+// nextDue returns the time of the next repeat or event held back by
+// the filters.
+func (p *Player) nextDue(filters []chordFilter) (next time.Time, isDue bool) {
+	if p.held != nil && !p.held.released && p.held.pressed == nil {
+		next, isDue = p.held.next, true
+	}
+	for _, f := range filters {
+		if due, isFilterDue := f.nextDue(); isFilterDue && (!isDue || due.Before(next)) {
+			next, isDue = due, true
+		}
+	}
+	return next, isDue
+}
+
+// catchUp plays the repeats and the events held back by the filters
+// that are due at the time t, in the order they are due. A repeat
+// that is due after a held back event sees what that event played.
+func (p *Player) catchUp(filters []chordFilter, t time.Time) error {
+	for {
+		next, isDue := p.nextDue(filters)
+		if !isDue || next.After(t) {
+			return nil
+		}
+
+		if held := p.held; held != nil && !held.released && !held.next.After(next) {
+			if err := p.repeatUntil(next); err != nil {
+				return err
+			}
+		}
+
+		for i, f := range filters {
+			if due, isFilterDue := f.nextDue(); isFilterDue && !due.After(next) {
+				if err := p.feed(filters[i+1:], f.expire(next)...); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// end plays the events the filters still hold back.
+func (p *Player) end(filters []chordFilter, t time.Time) error {
+	for i, f := range filters {
+		if err := p.feed(filters[i+1:], f.end(t)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// feed passes events through each of the filters in turn and plays
+// the events that come out of the last.
+func (p *Player) feed(filters []chordFilter, events ...input.Event) error {
+	for _, f := range filters {
+		var filtered []input.Event
+		for _, e := range events {
+			filtered = append(filtered, f.filter(e)...)
+		}
+		events = filtered
+	}
+
+	for _, e := range events {
+		if err := p.apply(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Player) apply(e input.Event) error {
+	switch e := e.(type) {
+	case input.KeyDown:
+		p.keys |= e.Keys
+
+	case input.KeyUp:
+		p.keys &^= e.Keys
+
+		// The chord stops repeating but is kept until it is committed
+		// so that it isn't played again
+		if held := p.held; held != nil && p.keys == 0 {
+			held.released = true
+			return releaseHeld(held, p.Output)
+		}
+
+	case input.ChordStarted:
+		p.changed = e.Time
+		p.hold(e.Chord, e.Time)
+
+	case input.ChordChanged:
+		p.changed = e.Time
+		if err := p.release(); err != nil {
+			return err
+		}
+		p.hold(e.To, e.Time)
+
+	case input.ChordCancelled:
+		return p.release()
+
+	case input.ChordCommitted:
+		held := p.held
+		if err := p.release(); err != nil {
+			return err
+		}
+
+		if held != nil && held.chord == e.Chord && held.repeated {
+			// Already played while it was held
+			return nil
+		}
+		return p.commit(e)
+
+	case Played:
+		p.setOrigin(e.Time)
+		key := p.modified(e.Key)
+		p.record(e.Chord, key)
+		return key.OutputTo(p.Output)
+
+	default:
+	}
+	return nil
+}
+
+// commit plays a chord that was committed.
+func (p *Player) commit(e input.ChordCommitted) error {
+	key, isBound := p.Keymap.Lookup(e.Chord)
+	if !isBound {
+		log.Println("unbound chord", e.Chord)
+		p.mods = nil
+		return nil
+	}
+
+	if dual, isDual := key.(TapHold); isDual {
+		if p.HoldThreshold > 0 && e.Time.Sub(p.changed) >= p.HoldThreshold {
+			p.mods = append(p.mods, dual.Hold)
+			return nil
+		}
+	}
+
+	p.setOrigin(e.Time)
+	switch key.(type) {
+	case Undo:
+		_, _, err := p.undo()
+		return err
+	case Retry:
+		return p.retry()
+	case Leader, BrailleCell:
+		// Played by the sequencer and the braille writer
+		return nil
+	default:
+	}
+
+	key = p.modified(key)
+	p.record(e.Chord, key)
+	return key.OutputTo(p.Output)
+}
+
+// modified wraps a key in the modifiers of any held TapHold chords,
+// which only apply to a single key.
+func (p *Player) modified(key OutputEvent) OutputEvent {
+	for _, mod := range p.mods {
+		key = Wrap{key, mod}
+	}
+	p.mods = nil
+	return key
+}
+
+// hold starts timing the repeat of a chord being built. The delay
+// starts again whenever the chord changes, so only a chord that is
+// held unchanged for the delay repeats.
+func (p *Player) hold(chord input.Chord, at time.Time) {
+	if p.keys == 0 || !p.Repeat.Enabled() {
+		return
+	}
+
+	key, isBound := p.Keymap.Lookup(chord)
+	if !isBound || !repeatable(key) {
+		return
+	}
+
+	p.held = &heldChord{
+		chord: chord,
+		key:   key,
+		next:  at.Add(p.Repeat.Delay),
+	}
+}
+
+// repeatable reports whether a key repeats when its chord is held.
+// A TapHold chord is held to change its role and the keys that act
+// on the state of a Player only make sense played once.
+func repeatable(key OutputEvent) bool {
+	switch key.(type) {
+	case TapHold, Undo, Retry, Leader, BrailleCell:
+		return false
+	default:
+	}
+	return true
+}
+
+// repeatUntil plays the repeats of the held chord that are due at
+// the time t.
+func (p *Player) repeatUntil(t time.Time) error {
+	held := p.held
+	for held.pressed == nil && !held.next.After(t) {
+		p.setOrigin(held.next)
+		if !held.repeated {
+			held.key = p.modified(held.key)
+			held.repeated = true
+			held.played = p.record(held.chord, held.key)
+		} else {
+			held.played.count++
+		}
+
+		if p.Repeat.Mode == RepeatHold {
+			if key, isHoldable := holdable(held.key); isHoldable {
+				held.pressed = key
+				return key.Press(p.Output)
+			}
+		}
+
+		if err := held.key.OutputTo(p.Output); err != nil {
+			return err
+		}
+
+		// An interval of 0 would repeat forever
+		interval := p.Repeat.Interval
+		if interval <= 0 {
+			interval = DefaultRepeatInterval
+		}
+		held.next = held.next.Add(interval)
+	}
+	return nil
+}
+
+// release lets go of any key held down by RepeatHold.
+func (p *Player) release() error {
+	held := p.held
+	p.held = nil
+	return releaseHeld(held, p.Output)
+}
+
+func releaseHeld(held *heldChord, output KeySink) error {
+	if held == nil || held.pressed == nil {
+		return nil
+	}
+
+	key := held.pressed
+	held.pressed = nil
+	return key.Release(output)
+}
+
+func (p *Player) setOrigin(t time.Time) {
+	if origin, isOriginSink := p.Output.(originSink); isOriginSink {
+		origin.SetOrigin(t)
+	}
+}
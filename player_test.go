@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+	"github.com/ghthor/uinput"
+)
+
+// testLayout binds a few letters, the keys that act on the state of
+// a Player and a leader with some sequences.
+var testLayout = func() Layout {
+	leader := NewLeader()
+	leader.Sequences.Add([]input.Chord{1 << PAD_W}, Letter(uinput.KEY_W))
+	leader.Sequences.Add([]input.Chord{1 << PAD_W, 1 << PAD_S}, Letter(uinput.KEY_Q))
+	leader.Sequences.Add([]input.Chord{1 << PAD_E}, Letter(uinput.KEY_X))
+
+	return Layout{
+		1 << PAD_N:              Letter(uinput.KEY_E),
+		1 << PAD_E:              Letter(uinput.KEY_T),
+		1 << PAD_S:              Letter(uinput.KEY_S),
+		1 << PAD_W:              Letter(uinput.KEY_A),
+		1<<PAD_N | 1<<(PAD_S+4): Letter(uinput.KEY_R),
+		1<<PAD_S | 1<<(PAD_S+4): leader,
+		1<<PAD_E | 1<<(PAD_E+4): Func(uinput.KEY_ESC),
+		1 << (PAD_N + 4):        TapHold{Func(FN_SPACE), uinput.KEY_LEFTSHIFT},
+		1 << (PAD_E + 4):        Undo{},
+		1 << (PAD_W + 4):        Retry{},
+	}
+}()
+
+func TestPlayer(t *testing.T) {
+	output := Repeat{RepeatOutput, 250 * time.Millisecond, 50 * time.Millisecond}
+	hold := Repeat{RepeatHold, 250 * time.Millisecond, 50 * time.Millisecond}
+
+	cases := []struct {
+		name   string
+		player Player
+		script string
+		want   string
+	}{
+		{"a chord plays when it is released",
+			Player{}, "left N, right S, release", "R"},
+		{"repeat is off by default",
+			Player{Repeat: DefaultRepeat}, "left N, wait 400ms, left up", "E"},
+		{"a held chord repeats its output",
+			Player{Repeat: output}, "left N, wait 400ms, left up", "E E E E"},
+		{"a chord that changes waits for the delay again",
+			Player{Repeat: output}, "left N, wait 200ms, right S, wait 200ms, release", "R"},
+		{"a chord held unchanged for the delay after a change repeats",
+			Player{Repeat: output}, "left N, wait 200ms, right S, wait 300ms, release", "R R"},
+		{"a held chord holds its key down",
+			Player{Repeat: hold}, "left N, wait 400ms, left up", "E"},
+		{"a tap/hold chord taps when released before the threshold",
+			Player{HoldThreshold: 200 * time.Millisecond}, "right N, right up, left N, left up", "SPACE E"},
+		{"a tap/hold chord held past the threshold modifies the next chord",
+			Player{HoldThreshold: 200 * time.Millisecond}, "right N, wait 300ms, right up, left N, left up", "LEFTSHIFT E"},
+		{"a tap/hold chord only taps without a threshold",
+			Player{}, "right N, wait 300ms, right up, left N, left up", "SPACE E"},
+		{"undo deletes the last character",
+			Player{}, "left N, left up, right E, right up", "E BACKSPACE"},
+		{"undo goes back through the history",
+			Player{}, "left N, left up, left E, left up, right E, right up, right E, right up",
+			"E T BACKSPACE BACKSPACE"},
+		{"undo deletes each repeat",
+			Player{Repeat: output}, "left N, wait 300ms, left up, right E, right up",
+			"E E BACKSPACE BACKSPACE"},
+		{"output that can't be undone stays in the history",
+			Player{}, "left N, left up, left E, right E, release, right E, right up, right E, right up",
+			"E ESC"},
+		{"retry plays the chord one key away",
+			Player{}, "left N, left up, right W, right up", "E BACKSPACE R"},
+		{"retry comes back to the chord once every neighbour is tried",
+			Player{}, "left N, left up, right W, right up, right W, right up",
+			"E BACKSPACE R BACKSPACE E"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := typed(t, c.script, testLayout, input.Timing{}, c.player)
+			if got != c.want {
+				t.Errorf("%q typed %q, want %q", c.script, got, c.want)
+			}
+		})
+	}
+}
+
+func TestPlayerPlaysDueEventsInOrder(t *testing.T) {
+	player := Player{
+		Repeat:         Repeat{RepeatOutput, 250 * time.Millisecond, 100 * time.Millisecond},
+		BrailleTimeout: time.Second,
+	}
+
+	// The word of the first cell times out while the backspace of
+	// the second chord is repeating
+	script := "press LT, release, left W, wait 1500ms, left up"
+	want := strings.Repeat("BACKSPACE ", 8) + "A" + strings.Repeat(" BACKSPACE", 5)
+	if got := typed(t, script, Layouts["braille2"], input.Timing{}, player); got != want {
+		t.Errorf("%q typed %q, want %q", script, got, want)
+	}
+}
+
+func TestPlayerUndoesARepeatedChord(t *testing.T) {
+	layout := Layout{}
+	for chord, key := range Layouts["braille2"] {
+		layout[chord] = key
+	}
+	layout[1<<(PAD_E+4)] = Undo{}
+
+	player := Player{
+		Repeat:         Repeat{RepeatOutput, 250 * time.Millisecond, 100 * time.Millisecond},
+		BrailleTimeout: time.Second,
+	}
+
+	// The word typed while the backspace repeats is the last output,
+	// and only it is undone
+	script := "press LT, release, left W, wait 1500ms, left up, right E, right up"
+	want := strings.Repeat("BACKSPACE ", 8) + "A" + strings.Repeat(" BACKSPACE", 6)
+	if got := typed(t, script, layout, input.Timing{}, player); got != want {
+		t.Errorf("%q typed %q, want %q", script, got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"syscall"
+)
+
+// A poller waits with epoll for a file descriptor to be readable.
+// A wait is interrupted when its context is done by writing to a
+// pipe that is polled with the file descriptor.
+type poller struct {
+	epfd int
+	wake [2]int
+
+	events []syscall.EpollEvent
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newPoller(fd int) (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &poller{epfd: epfd, events: make([]syscall.EpollEvent, 2)}
+	err = syscall.Pipe2(p.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC)
+	if err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+
+	for _, fd := range []int{fd, p.wake[0]} {
+		event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+		if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Wait blocks until the file descriptor is readable or has failed,
+// in which case reading it returns the error, or until ctx is done.
+func (p *poller) Wait(ctx context.Context) error {
+	// Wake the poller if ctx is done while it waits
+	waiting := make(chan struct{})
+	defer close(waiting)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Wake()
+		case <-waiting:
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := syscall.EpollWait(p.epfd, p.events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		ready := false
+		for _, event := range p.events[:n] {
+			if int(event.Fd) == p.wake[0] {
+				p.drain()
+			} else {
+				ready = true
+			}
+		}
+
+		if ready {
+			return nil
+		}
+	}
+}
+
+// Wake interrupts a Wait that is in progress.
+func (p *poller) Wake() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		// The pipe being full means a wake is already pending
+		syscall.Write(p.wake[1], []byte{0})
+	}
+}
+
+func (p *poller) drain() {
+	var b [16]byte
+	for {
+		if n, _ := syscall.Read(p.wake[0], b[:]); n <= 0 {
+			return
+		}
+	}
+}
+
+func (p *poller) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+	return syscall.Close(p.epfd)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+	evdev "github.com/ghthor/golang-evdev"
+)
+
+// Recordings use the same binary layout for each event as an evdev
+// device node, so `cat /dev/input/eventN > file` is also a valid
+// recording that can be replayed.
+
+// A recordingSource writes every event read from an eventSource
+// into a recording.
+type recordingSource struct {
+	eventSource
+	w io.Writer
+}
+
+func (src recordingSource) Unwrap() eventSource {
+	return src.eventSource
+}
+
+func (src recordingSource) ReadOne(ctx context.Context) (*evdev.InputEvent, error) {
+	e, err := src.eventSource.ReadOne(ctx)
+	if err != nil {
+		return e, err
+	}
+
+	return e, binary.Write(src.w, binary.LittleEndian, e)
+}
+
+// A replaySource reads events from a recording. If realtime is
+// set the events are delayed to match the time between each event
+// when they were recorded.
+type replaySource struct {
+	r io.Reader
+	io.Closer
+
+	realtime bool
+	last     syscall.Timeval
+}
+
+func (src *replaySource) ReadOne(ctx context.Context) (*evdev.InputEvent, error) {
+	e := evdev.InputEvent{}
+	err := binary.Read(src.r, binary.LittleEndian, &e)
+	if err != nil {
+		return &e, err
+	}
+
+	if src.realtime && src.last.Sec != 0 {
+		wait := time.Duration(e.Time.Nano() - src.last.Nano())
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return &e, ctx.Err()
+			}
+		}
+	}
+	src.last = e.Time
+
+	return &e, nil
+}
+
+func (src *replaySource) String() string {
+	if f, isFile := src.Closer.(*os.File); isFile {
+		return "recording " + f.Name()
+	}
+	return "recording"
+}
+
+func openRecording(path string, realtime bool) (*replaySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &replaySource{r: bufio.NewReader(f), Closer: f, realtime: realtime}, nil
+}
+
+func openEvdevDevice(path string) (*evdev.InputDevice, error) {
+	if path == "" {
+		return waitForEvdevDevice(context.Background(), "")
+	}
+	return evdev.Open(path)
+}
+
+// openEventSource plays the recording at replayPath in real time
+// or opens the evdev device at devicePath if there is no recording.
+func openEventSource(devicePath, replayPath string) (eventSource, error) {
+	if replayPath != "" {
+		return openRecording(replayPath, true)
+	}
+
+	device, err := openEvdevDevice(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	return newEvdevSource(device)
+}
+
+// record captures the input events from an evdev device into a
+// recording file. The chords produced by the events are printed
+// to stdout while recording.
+func record(args []string) error {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	devicePath := flags.String("device", "", "evdev device to record, auto selected if empty")
+	outputPath := flags.String("o", "chordpad.rec", "file to write the recording into")
+	selectedLayout := layoutFlag(flags)
+	flags.Parse(args)
+
+	layout, err := selectedLayout()
+	if err != nil {
+		return err
+	}
+
+	device, err := openEvdevDevice(*devicePath)
+	if err != nil {
+		return err
+	}
+	log.Println(device)
+
+	f, err := os.Create(*outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	log.Println("recording input events to", *outputPath)
+
+	events, err := newEvdevSource(device)
+	if err != nil {
+		return err
+	}
+
+	dev := newSteamController(recordingSource{events, f})
+	defer dev.Close()
+
+	source := input.Source{Device: dev}
+	player := &Player{Keymap: layout, Output: &TextSink{Writer: os.Stdout}}
+	err = send(context.Background(), &source, player)
+	if err != nil {
+		return err
+	}
+
+	return source.Err()
+}
+
+// replay plays a recording through the same pipeline used for a
+// live device. The output is printed to stdout unless a uinput
+// device path is given.
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFlags := newPlaybackFlags(flags)
+	realtime := flags.Bool("realtime", false, "replay events with their recorded timing")
+	uinputPath := flags.String("uinput", "", "send output to a virtual keyboard created with this uinput device")
+	keyInterval := flags.Duration("key-interval", DefaultKeyInterval,
+		"minimum time between key events sent to the virtual keyboard")
+	statsPath := flags.String("stats", "", "add the chords of the recording to a stats file")
+	flags.Parse(args)
+
+	config, err := configFlags.Config()
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("replay requires a single recording file")
+	}
+
+	layout, err := LookupLayout(config.Layout)
+	if err != nil {
+		return err
+	}
+
+	recording, err := openRecording(flags.Arg(0), *realtime)
+	if err != nil {
+		return err
+	}
+
+	dev := newSteamController(recording)
+	defer dev.Close()
+
+	var output KeySink = &TextSink{Writer: os.Stdout}
+	if *uinputPath != "" {
+		vk, err := NewVirtualKeyboard(*uinputPath, "Test Chordpad Device")
+		if err != nil {
+			return err
+		}
+		defer vk.Close()
+
+		paced := NewPacedSink(vk, *keyInterval, DefaultFrameQueue)
+		defer paced.Close()
+		output = paced
+	}
+
+	var stages []Stage
+	if *statsPath != "" {
+		stats, err := LoadStats(*statsPath)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, stats.Tap(layout, *statsPath))
+	}
+
+	// A recording doesn't keep the repeat rate of its device
+	source := input.Source{Device: dev, Timing: config.Timing()}
+	player := &Player{
+		Keymap:          layout,
+		Output:          output,
+		Repeat:          config.AutoRepeat(0, 0),
+		HoldThreshold:   config.HoldThreshold.Duration,
+		SequenceTimeout: config.SequenceTimeout.Duration,
+		BrailleTimeout:  config.BrailleTimeout.Duration,
+	}
+	err = send(context.Background(), &source, player, stages...)
+	if err != nil {
+		return err
+	}
+
+	if err := source.Err(); err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// DefaultSequenceTimeout is how long a Player waits for the next
+// chord of a sequence.
+const DefaultSequenceTimeout = time.Second
+
+// A Sequence is a node of a trie of the chords played after a
+// Leader. The Key of a node is played once the chords leading to it
+// are played, unless a longer sequence is continued before the
+// timeout.
+type Sequence struct {
+	Key  OutputEvent
+	Next map[input.Chord]*Sequence
+}
+
+// Add binds a sequence of chords to a key.
+func (s *Sequence) Add(chords []input.Chord, key OutputEvent) {
+	node := s
+	for _, chord := range chords {
+		next, exists := node.Next[chord]
+		if !exists {
+			next = &Sequence{}
+			if node.Next == nil {
+				node.Next = make(map[input.Chord]*Sequence)
+			}
+			node.Next[chord] = next
+		}
+		node = next
+	}
+	node.Key = key
+}
+
+// Walk calls fn with each sequence that is bound to a key, in
+// ascending order of chords.
+func (s *Sequence) Walk(fn func(chords []input.Chord, key OutputEvent) error) error {
+	return s.walk(nil, fn)
+}
+
+func (s *Sequence) walk(prefix []input.Chord, fn func([]input.Chord, OutputEvent) error) error {
+	if s.Key != nil && len(prefix) > 0 {
+		if err := fn(prefix, s.Key); err != nil {
+			return err
+		}
+	}
+
+	chords := make([]input.Chord, 0, len(s.Next))
+	for chord := range s.Next {
+		chords = append(chords, chord)
+	}
+	sortChords(chords)
+
+	for _, chord := range chords {
+		next := append(prefix[:len(prefix):len(prefix)], chord)
+		if err := s.Next[chord].walk(next, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Leader starts a sequence of chords that is looked up in its
+// trie instead of the layout, e.g. leader, W, Q to close a window.
+// It only has an effect when played by a Player.
+type Leader struct {
+	Sequences *Sequence
+}
+
+func NewLeader() Leader {
+	return Leader{&Sequence{}}
+}
+
+func (Leader) OutputTo(sink KeySink) error { return nil }
+func (Leader) String() string              { return "leader" }
+
+// formatSequence writes the chords of a sequence the way they are
+// written in a layout file, e.g. "20 9 40".
+func formatSequence(chords []input.Chord) string {
+	words := make([]string, len(chords))
+	for i, chord := range chords {
+		words[i] = strconv.Itoa(int(chord))
+	}
+	return strings.Join(words, " ")
+}
+
+// parseSequence is the inverse of formatSequence.
+func parseSequence(s string) ([]input.Chord, error) {
+	var chords []input.Chord
+	for _, word := range strings.Fields(s) {
+		c, err := strconv.ParseUint(word, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chord %q", word)
+		}
+		chords = append(chords, input.Chord(c))
+	}
+	return chords, nil
+}
+
+// A pendingSequence is a sequence that has been started and is
+// waiting for its next chord.
+type pendingSequence struct {
+	node     *Sequence
+	chords   []input.Chord
+	deadline time.Time
+
+	// The chord being built and when it last changed, held back
+	// until the sequence ends
+	build   input.Chord
+	changed time.Time
+}
+
+// started returns the start of the chord being built when the
+// sequence ends, so the filters after the sequencer see when it was
+// started before it is committed.
+func (seq *pendingSequence) started() []input.Event {
+	if seq.build == 0 {
+		return nil
+	}
+	return []input.Event{input.ChordStarted{Chord: seq.build, Time: seq.changed}}
+}
+
+// A sequencer plays the sequences started by a Leader chord. The
+// chords that follow a leader are looked up in its trie instead of
+// the layout and their events are held back from the filters after
+// it, which are sent the key of the sequence once it is played.
+type sequencer struct {
+	keymap  Keymap
+	timeout time.Duration
+
+	pending *pendingSequence
+}
+
+func (s *sequencer) filter(e input.Event) []input.Event {
+	if s.pending == nil {
+		if committed, isCommit := e.(input.ChordCommitted); isCommit {
+			s.start(committed)
+		}
+		return []input.Event{e}
+	}
+
+	switch e := e.(type) {
+	case input.KeyDown, input.KeyUp:
+		return []input.Event{e}
+	case input.ChordStarted:
+		s.pending.build, s.pending.changed = e.Chord, e.Time
+	case input.ChordChanged:
+		s.pending.build, s.pending.changed = e.To, e.Time
+	case input.ChordCancelled:
+		s.pending.build = 0
+	case input.ChordCommitted:
+		return s.next(e)
+	default:
+	}
+	return nil
+}
+
+// start waits for the chords that follow a leader. The leader is
+// still sent on, so the filters after it see a word end.
+func (s *sequencer) start(e input.ChordCommitted) {
+	key, isBound := s.keymap.Lookup(e.Chord)
+	leader, isLeader := key.(Leader)
+	if !isBound || !isLeader {
+		return
+	}
+
+	s.pending = &pendingSequence{
+		node:     leader.Sequences,
+		chords:   []input.Chord{e.Chord},
+		deadline: e.Time.Add(s.timeout),
+	}
+	log.Printf("sequence %s pending", formatSequence(s.pending.chords))
+}
+
+// next looks up a chord in the pending sequence. A chord that doesn't
+// continue the sequence ends it, playing its key if the chords so far
+// are bound to one, and is then played as if no sequence was pending.
+func (s *sequencer) next(e input.ChordCommitted) []input.Event {
+	seq := s.pending
+	next, exists := seq.node.Next[e.Chord]
+	if !exists {
+		s.pending = nil
+
+		var events []input.Event
+		if seq.node.Key != nil {
+			events = s.play(seq.chords, seq.node.Key, e.Time)
+		} else {
+			log.Printf("sequence %s isn't bound, cancelled",
+				formatSequence(append(seq.chords, e.Chord)))
+		}
+		events = append(events, seq.started()...)
+		return append(events, s.filter(e)...)
+	}
+
+	seq.chords = append(seq.chords, e.Chord)
+	seq.build = 0
+
+	if len(next.Next) == 0 {
+		s.pending = nil
+		return s.play(seq.chords, next.Key, e.Time)
+	}
+
+	seq.node, seq.deadline = next, e.Time.Add(s.timeout)
+	log.Printf("sequence %s pending", formatSequence(seq.chords))
+	return nil
+}
+
+// expire ends the pending sequence once its timeout has passed,
+// playing its key if the chords so far are bound to one.
+func (s *sequencer) expire(now time.Time) []input.Event {
+	seq := s.pending
+	if seq == nil || now.Before(seq.deadline) {
+		return nil
+	}
+	s.pending = nil
+
+	var events []input.Event
+	if seq.node.Key != nil {
+		events = s.play(seq.chords, seq.node.Key, seq.deadline)
+	} else {
+		log.Printf("sequence %s timed out", formatSequence(seq.chords))
+	}
+	return append(events, seq.started()...)
+}
+
+func (s *sequencer) nextDue() (time.Time, bool) {
+	if s.pending == nil {
+		return time.Time{}, false
+	}
+	return s.pending.deadline, true
+}
+
+// end drops a sequence that is still pending when the events end.
+func (s *sequencer) end(now time.Time) []input.Event {
+	s.pending = nil
+	return nil
+}
+
+func (s *sequencer) play(chords []input.Chord, key OutputEvent, at time.Time) []input.Event {
+	log.Printf("sequence %s played", formatSequence(chords))
+	return []input.Event{Played{Chord: chords[len(chords)-1], Key: key, Time: at}}
+}
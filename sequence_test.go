@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+func TestSequences(t *testing.T) {
+	const leader = "left S, right S, release"
+
+	cases := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"a sequence plays its key",
+			leader + ", left W, left up, left S, left up", "Q"},
+		{"a sequence times out with the key of the chords so far",
+			leader + ", left W, left up, wait 2s, left N, left up", "W E"},
+		{"a chord that doesn't continue a sequence plays after it",
+			leader + ", left W, left up, left N, left up", "W E"},
+		{"a chord that doesn't start a sequence plays on its own",
+			leader + ", left N, left up", "E"},
+		{"a leader ends a sequence and starts the next",
+			leader + ", left W, left up, " + leader + ", left E, left up", "W X"},
+		{"a sequence without a key times out",
+			leader + ", wait 2s, left N, left up", "E"},
+		{"a chord of a sequence isn't repeated",
+			leader + ", left W, wait 600ms, left up, left S, left up", "Q"},
+		{"a tap/hold chord that ends a sequence is timed from its start",
+			leader + ", wait 500ms, right N, right up, left N, left up", "SPACE E"},
+		{"a tap/hold chord built as a sequence times out is timed from its start",
+			leader + ", wait 900ms, right N, wait 150ms, right up, left N, left up", "SPACE E"},
+	}
+
+	player := Player{
+		Repeat:          Repeat{RepeatOutput, 250 * time.Millisecond, 50 * time.Millisecond},
+		HoldThreshold:   200 * time.Millisecond,
+		SequenceTimeout: time.Second,
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := typed(t, c.script, testLayout, input.Timing{}, player)
+			if got != c.want {
+				t.Errorf("%q typed %q, want %q", c.script, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseSequence(t *testing.T) {
+	chords, err := parseSequence("20 8 1")
+	if want := []input.Chord{20, 8, 1}; err != nil || !reflect.DeepEqual(chords, want) {
+		t.Errorf("got %v, %v, want %v", chords, err, want)
+	}
+
+	if formatted := formatSequence(chords); formatted != "20 8 1" {
+		t.Errorf("formatted as %q", formatted)
+	}
+
+	_, err = parseSequence("20 x 1")
+	if err == nil || !strings.Contains(err.Error(), `"x"`) {
+		t.Errorf("got error %v, want it to quote the invalid chord", err)
+	}
+}
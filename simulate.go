@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+	evdev "github.com/ghthor/golang-evdev"
+)
+
+// A scenario is a script of steam controller input that is played
+// as evdev events without any hardware. Steps are separated by
+// commas and each step is written as a SYN_REPORT frame.
+//
+//	left N        touch the left pad in the N zone (N, S, E or W)
+//	right S       touch the right pad in the S zone
+//	left up       lift the thumb off the left pad
+//	press A       press a button (A, B, TL, TR, THUMBL, THUMBR, LT, RT)
+//	lift A        release a button
+//	wait 50ms     advance the clock before the next step
+//	release       lift every pad and button that is held
+//
+// e.g. "left N, right S, release" keys the chord N|S<<4.
+
+// ScenarioStep is the time between each step of a scenario.
+const ScenarioStep = 10 * time.Millisecond
+
+var scenarioZones = map[string][2]int32{
+	"N": {0, math.MaxInt16},
+	"S": {0, -math.MaxInt16},
+	"E": {math.MaxInt16, 0},
+	"W": {-math.MaxInt16, 0},
+}
+
+var scenarioPads = map[string][2]uint16{
+	"left":  {evdev.ABS_HAT0X, evdev.ABS_HAT0Y},
+	"right": {evdev.ABS_RX, evdev.ABS_RY},
+}
+
+var scenarioButtons = map[string]uint16{
+	"A":      evdev.BTN_A,
+	"B":      evdev.BTN_B,
+	"TL":     evdev.BTN_TL,
+	"TR":     evdev.BTN_TR,
+	"THUMBL": evdev.BTN_THUMBL,
+	"THUMBR": evdev.BTN_THUMBR,
+}
+
+var scenarioTriggers = map[string]uint16{
+	"LT": evdev.ABS_Z,
+	"RT": evdev.ABS_RZ,
+}
+
+type scenarioWriter struct {
+	events []evdev.InputEvent
+	now    time.Duration
+
+	pads    map[string]bool
+	buttons map[string]bool
+}
+
+func (w *scenarioWriter) emit(typ, code uint16, value int32) {
+	w.events = append(w.events, evdev.InputEvent{
+		// Recorded events are never at time 0
+		Time:  syscall.NsecToTimeval(int64(time.Second + w.now)),
+		Type:  typ,
+		Code:  code,
+		Value: value,
+	})
+}
+
+func (w *scenarioWriter) syn() {
+	w.emit(evdev.EV_SYN, evdev.SYN_REPORT, 0)
+	w.now += ScenarioStep
+}
+
+func (w *scenarioWriter) touch(pad string, x, y int32) {
+	axes := scenarioPads[pad]
+	w.emit(evdev.EV_ABS, axes[0], x)
+	w.emit(evdev.EV_ABS, axes[1], y)
+	w.pads[pad] = x|y != 0
+}
+
+func (w *scenarioWriter) button(name string, down bool) error {
+	var value int32
+	if down {
+		value = 1
+	}
+
+	if code, exists := scenarioButtons[name]; exists {
+		w.emit(evdev.EV_KEY, code, value)
+	} else if code, exists := scenarioTriggers[name]; exists {
+		w.emit(evdev.EV_ABS, code, 255*value)
+	} else {
+		return fmt.Errorf("unknown button %q", name)
+	}
+
+	w.buttons[name] = down
+	return nil
+}
+
+func (w *scenarioWriter) release() {
+	var held []string
+	for name, down := range w.buttons {
+		if down {
+			held = append(held, name)
+		}
+	}
+	sort.Strings(held)
+
+	for _, name := range held {
+		w.button(name, false)
+	}
+
+	for _, pad := range []string{"left", "right"} {
+		if w.pads[pad] {
+			w.touch(pad, 0, 0)
+		}
+	}
+}
+
+func (w *scenarioWriter) step(step string) error {
+	fields := strings.Fields(step)
+	if len(fields) == 0 {
+		return errors.New("empty step")
+	}
+
+	if fields[0] == "release" && len(fields) == 1 {
+		w.release()
+		w.syn()
+		return nil
+	}
+
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid step %q", step)
+	}
+
+	switch verb, arg := fields[0], fields[1]; verb {
+	case "left", "right":
+		if arg == "up" {
+			w.touch(verb, 0, 0)
+			break
+		}
+
+		zone, exists := scenarioZones[strings.ToUpper(arg)]
+		if !exists {
+			return fmt.Errorf("unknown pad zone %q", arg)
+		}
+		w.touch(verb, zone[0], zone[1])
+
+	case "press", "lift":
+		if err := w.button(strings.ToUpper(arg), verb == "press"); err != nil {
+			return err
+		}
+
+	case "wait":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return err
+		}
+		w.now += d
+		return nil
+
+	default:
+		return fmt.Errorf("unknown step %q", step)
+	}
+
+	w.syn()
+	return nil
+}
+
+// ParseScenario converts a scenario script into the evdev events
+// a steam controller would produce.
+func ParseScenario(script string) ([]evdev.InputEvent, error) {
+	w := scenarioWriter{
+		pads:    make(map[string]bool),
+		buttons: make(map[string]bool),
+	}
+
+	for _, step := range strings.Split(script, ",") {
+		if err := w.step(step); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.events, nil
+}
+
+// A scenarioSource is an eventSource that plays a list of events
+// and then returns io.EOF.
+type scenarioSource struct {
+	events []evdev.InputEvent
+}
+
+func (src *scenarioSource) ReadOne(ctx context.Context) (*evdev.InputEvent, error) {
+	if len(src.events) == 0 {
+		return &evdev.InputEvent{}, io.EOF
+	}
+
+	e := src.events[0]
+	src.events = src.events[1:]
+	return &e, nil
+}
+
+func (src *scenarioSource) Close() error {
+	return nil
+}
+
+func (src *scenarioSource) String() string {
+	return "simulated steam controller"
+}
+
+// NewSimulator creates an input.Device that plays the scenario as
+// a steam controller.
+func NewSimulator(script string) (input.Device, error) {
+	events, err := ParseScenario(script)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSteamController(&scenarioSource{events}), nil
+}
+
+// Simulate plays a scenario through the chord pipeline and returns
+// the key events that were output. The player sets how held chords
+// are played and is given the layout and the output.
+func Simulate(script string, layout Layout, timing input.Timing, player Player) (*Recorder, error) {
+	dev, err := NewSimulator(script)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &Recorder{}
+	source := input.Source{Device: dev, Timing: timing}
+	player.Keymap, player.Output = layout, output
+	err = send(context.Background(), &source, &player)
+	if err != nil {
+		return output, err
+	}
+
+	if err := source.Err(); err != io.EOF {
+		return output, err
+	}
+	return output, nil
+}
+
+// simulate plays the scenarios given as arguments and prints the
+// frames of key events each one produced.
+func simulate(args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configFlags := newPlaybackFlags(flags)
+	flags.Parse(args)
+
+	config, err := configFlags.Config()
+	if err != nil {
+		return err
+	}
+
+	layout, err := LookupLayout(config.Layout)
+	if err != nil {
+		return err
+	}
+
+	// Steps are only ScenarioStep apart, so presses and releases
+	// aren't combined unless the config has a press window or
+	// release grace.
+	player := Player{
+		Repeat:          config.AutoRepeat(0, 0),
+		HoldThreshold:   config.HoldThreshold.Duration,
+		SequenceTimeout: config.SequenceTimeout.Duration,
+		BrailleTimeout:  config.BrailleTimeout.Duration,
+	}
+	for _, script := range flags.Args() {
+		output, err := Simulate(script, layout, config.Timing(), player)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "%s:\n", script)
+		for _, frame := range output.Frames {
+			fmt.Fprintf(os.Stdout, "\t%v\n", frame)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+	evdev "github.com/ghthor/golang-evdev"
+)
+
+// typed plays a scenario and describes each frame of output by the
+// keys it pressed, e.g. "RIGHTSHIFT A".
+func typed(t *testing.T, script string, layout Layout, timing input.Timing, player Player) string {
+	t.Helper()
+
+	output, err := Simulate(script, layout, timing, player)
+	if err != nil {
+		t.Fatalf("%q: %v", script, err)
+	}
+
+	var frames []string
+	for _, frame := range output.Frames {
+		var keys []string
+		for _, e := range frame {
+			if e.Down {
+				keys = append(keys, strings.TrimPrefix(evdev.KEY[e.Key], "KEY_"))
+			}
+		}
+		if len(keys) > 0 {
+			frames = append(frames, strings.Join(keys, "+"))
+		}
+	}
+	return strings.Join(frames, " ")
+}
+
+func TestParseScenario(t *testing.T) {
+	cases := []struct {
+		script string
+		isOk   bool
+	}{
+		{"left N, right S, release", true},
+		{"press A, wait 50ms, lift A", true},
+		{"press LT, release", true},
+		{"left X", false},
+		{"press Z", false},
+		{"wait soon", false},
+		{"left N,, release", false},
+	}
+
+	for _, c := range cases {
+		_, err := ParseScenario(c.script)
+		if isOk := err == nil; isOk != c.isOk {
+			t.Errorf("%q: got error %v", c.script, err)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"a chord plays when a thumb is lifted",
+			"left N, left up", "E"},
+		{"a chord of both pads",
+			"left N, right W, release", "V"},
+		{"sliding to another zone adds it to the chord",
+			"left N, left W, left up", "C"},
+		{"a chord is played once per release",
+			"left N, left up, left W, left up", "E A"},
+		{"a button modifies the chord",
+			"press THUMBL, left W, release", "RIGHTSHIFT A"},
+		{"nothing is played without a release",
+			"left N, left W", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := typed(t, c.script, Chords, input.Timing{}, Player{}); got != c.want {
+				t.Errorf("%q typed %q, want %q", c.script, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSimulateTiming(t *testing.T) {
+	cases := []struct {
+		name   string
+		timing input.Timing
+		script string
+		want   string
+	}{
+		{"a late press is combined within the press window",
+			input.Timing{PressWindow: 30 * time.Millisecond},
+			"left N, left up, right W, release", "V"},
+		{"a late press is a chord of its own without the press window",
+			input.Timing{},
+			"left N, left up, right W, release", "E N"},
+		{"rollover plays the next chord while a key is held",
+			input.Timing{Rollover: true},
+			"left N, right W, left up, left E, left up, release", "V T"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := typed(t, c.script, Chords, c.timing, Player{}); got != c.want {
+				t.Errorf("%q typed %q, want %q", c.script, got, c.want)
+			}
+		})
+	}
+}
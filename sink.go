@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	evdev "github.com/ghthor/golang-evdev"
+)
+
+// A KeySink is the destination for the key events produced by an
+// OutputEvent. Press and release events are grouped into frames
+// that are completed by calling Sync.
+type KeySink interface {
+	KeyPress(key int) error
+	KeyRelease(key int) error
+	Sync() error
+}
+
+// A HeldKeys sink remembers the keys that are down in the KeySink
+// it wraps so they can be released if output stops between the
+// press and release of a key. It isn't safe to use from multiple
+// goroutines.
+type HeldKeys struct {
+	KeySink
+	held map[int]bool
+}
+
+func NewHeldKeys(sink KeySink) *HeldKeys {
+	return &HeldKeys{sink, make(map[int]bool)}
+}
+
+func (s *HeldKeys) KeyPress(key int) error {
+	err := s.KeySink.KeyPress(key)
+	if err == nil {
+		s.held[key] = true
+	}
+	return err
+}
+
+func (s *HeldKeys) KeyRelease(key int) error {
+	err := s.KeySink.KeyRelease(key)
+	if err == nil {
+		delete(s.held, key)
+	}
+	return err
+}
+
+// ReleaseAll releases every key that is down.
+func (s *HeldKeys) ReleaseAll() error {
+	if len(s.held) == 0 {
+		return nil
+	}
+
+	var keys []int
+	for key := range s.held {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	for _, key := range keys {
+		log.Println("releasing held key", KeyEvent{key, false})
+		if err := s.KeyRelease(key); err != nil {
+			return err
+		}
+	}
+	return s.Sync()
+}
+
+// A KeyEvent is a single key event that was sent to a KeySink.
+type KeyEvent struct {
+	Key  int
+	Down bool
+}
+
+func (e KeyEvent) String() string {
+	name, exists := evdev.KEY[e.Key]
+	if !exists {
+		name = fmt.Sprint(e.Key)
+	}
+
+	if e.Down {
+		return "+" + name
+	}
+	return "-" + name
+}
+
+// A Recorder is a KeySink that stores every frame of key events
+// it receives in memory.
+type Recorder struct {
+	Frames [][]KeyEvent
+
+	pending []KeyEvent
+}
+
+func (r *Recorder) KeyPress(key int) error {
+	r.pending = append(r.pending, KeyEvent{key, true})
+	return nil
+}
+
+func (r *Recorder) KeyRelease(key int) error {
+	r.pending = append(r.pending, KeyEvent{key, false})
+	return nil
+}
+
+func (r *Recorder) Sync() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	r.Frames = append(r.Frames, r.pending)
+	r.pending = nil
+	return nil
+}
+
+// Events returns all the key events that have been recorded in
+// the order they were received.
+func (r *Recorder) Events() []KeyEvent {
+	var events []KeyEvent
+	for _, frame := range r.Frames {
+		events = append(events, frame...)
+	}
+	return events
+}
+
+// Reset discards all recorded frames.
+func (r *Recorder) Reset() {
+	r.Frames = nil
+	r.pending = nil
+}
+
+// A TextSink is a KeySink that writes a line for each frame of
+// key events to an io.Writer. It is used for debugging output
+// without creating a uinput device.
+type TextSink struct {
+	io.Writer
+
+	pending []string
+}
+
+func (s *TextSink) KeyPress(key int) error {
+	s.pending = append(s.pending, KeyEvent{key, true}.String())
+	return nil
+}
+
+func (s *TextSink) KeyRelease(key int) error {
+	s.pending = append(s.pending, KeyEvent{key, false}.String())
+	return nil
+}
+
+func (s *TextSink) Sync() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	_, err := fmt.Fprintln(s.Writer, strings.Join(s.pending, " "))
+	s.pending = s.pending[:0]
+	return err
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// StatsSaveInterval is the minimum time between saves of the stats
+// file while chords are being played.
+const StatsSaveInterval = 30 * time.Second
+
+// StatsIdleInterval is the longest time since the previous trigger
+// that is counted as the time taken to play a chord. Longer pauses,
+// and the gaps while a device is reconnected, are idle time.
+const StatsIdleInterval = 5 * time.Second
+
+// ChordStats are the usage statistics of a single chord.
+type ChordStats struct {
+	Count int  `json:"count"`
+	Bound bool `json:"bound"`
+
+	// Sum of the time since the previous trigger and the number of
+	// triggers it was summed for, used to find the mean time it takes
+	// to play the chord. Triggers after an idle pause aren't summed.
+	Interval  Duration `json:"interval"`
+	Intervals int      `json:"intervals"`
+
+	// For unbound chords, the number of times each chord was played
+	// immediately before and after it. A misfire is often followed
+	// by the chord that was intended.
+	Before map[input.Chord]int `json:"before,omitempty"`
+	After  map[input.Chord]int `json:"after,omitempty"`
+}
+
+// MeanInterval is the average time since the previous trigger.
+func (s ChordStats) MeanInterval() time.Duration {
+	if s.Intervals == 0 {
+		return 0
+	}
+	return s.Interval.Duration / time.Duration(s.Intervals)
+}
+
+// Stats counts the chords that are played and the output they
+// produce. It is safe to use from multiple goroutines.
+type Stats struct {
+	Chords  map[input.Chord]*ChordStats `json:"chords"`
+	Outputs map[string]int              `json:"outputs"`
+
+	mu          sync.Mutex
+	last        input.Chord
+	lastTrigger time.Time
+	lastSave    time.Time
+	dirty       bool
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		Chords:  make(map[input.Chord]*ChordStats),
+		Outputs: make(map[string]int),
+	}
+}
+
+// LoadStats reads a stats file. A missing file has no stats.
+func LoadStats(path string) (*Stats, error) {
+	stats := NewStats()
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, stats); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if stats.Chords == nil {
+		stats.Chords = make(map[input.Chord]*ChordStats)
+	}
+	if stats.Outputs == nil {
+		stats.Outputs = make(map[string]int)
+	}
+	return stats, nil
+}
+
+// Save writes the stats file, creating its directory.
+func (s *Stats) Save(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	s.lastSave = time.Now()
+	s.dirty = false
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+func (s *Stats) chord(chord input.Chord) *ChordStats {
+	stats := s.Chords[chord]
+	if stats == nil {
+		stats = &ChordStats{}
+		s.Chords[chord] = stats
+	}
+	return stats
+}
+
+// Trigger records a chord being played at the time of the input
+// event that played it.
+func (s *Stats) Trigger(keymap Keymap, chord input.Chord, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.chord(chord)
+	stats.Count++
+
+	interval := at.Sub(s.lastTrigger)
+	if !s.lastTrigger.IsZero() && interval >= 0 && interval <= StatsIdleInterval {
+		stats.Interval.Duration += interval
+		stats.Intervals++
+	}
+
+	key, isBound := keymap.Lookup(chord)
+	stats.Bound = isBound
+	if isBound {
+		s.Outputs[describeOutput(key)]++
+	} else {
+		if stats.Before == nil {
+			stats.Before = make(map[input.Chord]int)
+		}
+		if s.last != 0 {
+			stats.Before[s.last]++
+		}
+	}
+
+	if last := s.Chords[s.last]; last != nil && !last.Bound {
+		if last.After == nil {
+			last.After = make(map[input.Chord]int)
+		}
+		last.After[chord]++
+	}
+
+	s.last = chord
+	s.lastTrigger = at
+	s.dirty = true
+}
+
+// Tap records the commits of a channel of chord events and passes
+// every event through unchanged. If path isn't empty the stats are
+// saved to it periodically and when the channel is closed.
+func (s *Stats) Tap(keymap Keymap, path string) Stage {
+	save := func() {
+		if path == "" {
+			return
+		}
+		if err := s.Save(path); err != nil {
+			log.Println("saving stats:", err)
+		}
+	}
+
+	return func(ctx context.Context, events <-chan input.Event) <-chan input.Event {
+		output := make(chan input.Event)
+
+		go func() {
+			defer close(output)
+			defer save()
+
+			for e := range events {
+				if committed, isCommit := e.(input.ChordCommitted); isCommit {
+					s.Trigger(keymap, committed.Chord, committed.Time)
+
+					s.mu.Lock()
+					due := s.dirty && time.Since(s.lastSave) > StatsSaveInterval
+					s.mu.Unlock()
+					if due {
+						save()
+					}
+				}
+
+				select {
+				case output <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		return output
+	}
+}
+
+type chordCount struct {
+	input.Chord
+	Count int
+}
+
+func sortedCounts(counts map[input.Chord]int) []chordCount {
+	var sorted []chordCount
+	for chord, count := range counts {
+		sorted = append(sorted, chordCount{chord, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count == sorted[j].Count {
+			return sorted[i].Chord < sorted[j].Chord
+		}
+		return sorted[i].Count > sorted[j].Count
+	})
+	return sorted
+}
+
+func formatCounts(counts []chordCount, n int) string {
+	s := ""
+	for i, c := range counts {
+		if i == n {
+			break
+		}
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%d (%dx)", c.Chord, c.Count)
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// boundNeighbors returns the bound chords of a layout that differ
+// from a chord by a single bit.
+func boundNeighbors(layout Layout, chord input.Chord) []input.Chord {
+	var neighbors []input.Chord
+	for bit := input.Chord(1); bit != 0 && bit <= MOD_META; bit <<= 1 {
+		if _, isBound := layout[chord^bit]; isBound {
+			neighbors = append(neighbors, chord^bit)
+		}
+	}
+	sortChords(neighbors)
+	return neighbors
+}
+
+// Report writes the chords ordered by use, the outputs ordered by
+// use and the unbound chords with their neighbors.
+func (s *Stats) Report(w io.Writer, layout Layout) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[input.Chord]int)
+	for chord, stats := range s.Chords {
+		counts[chord] = stats.Count
+	}
+	chords := sortedCounts(counts)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "chord\tcount\tmean interval\toutput")
+	for _, c := range chords {
+		stats := s.Chords[c.Chord]
+		if !stats.Bound {
+			continue
+		}
+
+		output := "-"
+		if key, isBound := layout.Lookup(c.Chord); isBound {
+			output = describeOutput(key)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%v\t%s\n", c.Chord, c.Count,
+			stats.MeanInterval().Round(time.Millisecond), output)
+	}
+	tw.Flush()
+
+	var outputs []string
+	for output := range s.Outputs {
+		outputs = append(outputs, output)
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		if s.Outputs[outputs[i]] == s.Outputs[outputs[j]] {
+			return outputs[i] < outputs[j]
+		}
+		return s.Outputs[outputs[i]] > s.Outputs[outputs[j]]
+	})
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "output\tcount")
+	for _, output := range outputs {
+		fmt.Fprintf(tw, "%s\t%d\n", output, s.Outputs[output])
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "unbound\tcount\tbefore\tafter\tbound neighbors")
+	for _, c := range chords {
+		stats := s.Chords[c.Chord]
+		if stats.Bound {
+			continue
+		}
+
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", c.Chord, c.Count,
+			formatCounts(sortedCounts(stats.Before), 3),
+			formatCounts(sortedCounts(stats.After), 3),
+			formatChords(boundNeighbors(layout, c.Chord)))
+	}
+	tw.Flush()
+}
+
+// statsCommand prints a report of the stats file.
+func statsCommand(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	statsPath := flags.String("stats", defaultDataPath("stats.json"), "stats file to report")
+	selectedLayout := layoutFlag(flags)
+	flags.Parse(args)
+
+	layout, err := selectedLayout()
+	if err != nil {
+		return err
+	}
+
+	stats, err := LoadStats(*statsPath)
+	if err != nil {
+		return err
+	}
+
+	stats.Report(os.Stdout, layout)
+	return nil
+}
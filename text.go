@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+
+	"github.com/ghthor/uinput"
+)
+
+// textKeys are the keys that type each character on a US keyboard.
+// A backspace, '\b', deletes a character.
+var textKeys = func() map[rune]OutputEvent {
+	keys := map[rune]OutputEvent{
+		' ':  Func(FN_SPACE),
+		'\n': Func(FN_ENTER),
+		'\t': Func(FN_TAB),
+		'\b': Func(FN_BACKSPACE),
+	}
+
+	for i, key := range alphabet {
+		keys[rune('a'+i)] = Letter(key)
+		keys[rune('A'+i)] = ShiftPlus{Letter(key)}
+	}
+
+	digits := "1234567890"
+	symbols := "!@#$%^&*()"
+	for i := range digits {
+		key := Num(uinput.KEY_1 + i)
+		keys[rune(digits[i])] = key
+		keys[rune(symbols[i])] = ShiftPlus{key}
+	}
+
+	punctuation := []struct {
+		code           int
+		plain, shifted rune
+	}{
+		{uinput.KEY_MINUS, '-', '_'},
+		{uinput.KEY_EQUAL, '=', '+'},
+		{uinput.KEY_LEFTBRACE, '[', '{'},
+		{uinput.KEY_RIGHTBRACE, ']', '}'},
+		{uinput.KEY_SEMICOLON, ';', ':'},
+		{uinput.KEY_APOSTROPHE, '\'', '"'},
+		{uinput.KEY_GRAVE, '`', '~'},
+		{uinput.KEY_BACKSLASH, '\\', '|'},
+		{uinput.KEY_COMMA, ',', '<'},
+		{uinput.KEY_DOT, '.', '>'},
+		{uinput.KEY_SLASH, '/', '?'},
+	}
+	for _, p := range punctuation {
+		keys[p.plain] = Func(p.code)
+		keys[p.shifted] = ShiftPlus{Func(p.code)}
+	}
+
+	return keys
+}()
+
+// Text types a string with the keys of a US keyboard. Characters
+// that have no key are skipped.
+type Text string
+
+func (text Text) OutputTo(sink KeySink) error {
+	for _, c := range text {
+		key, exists := textKeys[c]
+		if !exists {
+			log.Printf("no key types %q", c)
+			continue
+		}
+
+		if err := key.OutputTo(sink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
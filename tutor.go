@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/ghthor/chordpad/input"
+)
+
+// tutorLessons are practiced when no lesson file is given.
+var tutorLessons = []string{
+	"the quick brown fox jumps over the lazy dog",
+	"east tea seat tease ate sat",
+	"one nine pin ion open nope",
+	"this is the time to try it",
+	"hello world",
+}
+
+// CharProgress is the practice history of a single character.
+type CharProgress struct {
+	Attempts int `json:"attempts"`
+	Errors   int `json:"errors"`
+}
+
+// ErrorRate is the fraction of attempts that played the wrong chord.
+func (p CharProgress) ErrorRate() float64 {
+	if p.Attempts == 0 {
+		return 0
+	}
+	return float64(p.Errors) / float64(p.Attempts)
+}
+
+// TutorProgress is saved between tutor sessions.
+type TutorProgress struct {
+	Sessions int                      `json:"sessions"`
+	Lines    int                      `json:"lines"`
+	BestWPM  float64                  `json:"best_wpm"`
+	LastWPM  float64                  `json:"last_wpm"`
+	Chars    map[string]*CharProgress `json:"chars"`
+}
+
+func defaultDataPath(name string) string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	return filepath.Join(dir, "chordpad", name)
+}
+
+// LoadTutorProgress reads the progress file at path. A missing file
+// is the progress of a new student.
+func LoadTutorProgress(path string) (*TutorProgress, error) {
+	progress := &TutorProgress{Chars: make(map[string]*CharProgress)}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, progress); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if progress.Chars == nil {
+		progress.Chars = make(map[string]*CharProgress)
+	}
+	return progress, nil
+}
+
+// Save writes the progress to path, creating its directory.
+func (p *TutorProgress) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+func (p *TutorProgress) char(c rune) *CharProgress {
+	key := string(c)
+	if p.Chars[key] == nil {
+		p.Chars[key] = &CharProgress{}
+	}
+	return p.Chars[key]
+}
+
+// chordsFor returns the easiest chord of a layout for each output.
+func chordsFor(layout Layout) map[string]input.Chord {
+	chords := make(map[string]input.Chord)
+	for _, chord := range layout.Chords() {
+		output := describeOutput(layout[chord])
+		if existing, exists := chords[output]; !exists ||
+			DefaultChordEffort.Of(chord) < DefaultChordEffort.Of(existing) {
+			chords[output] = chord
+		}
+	}
+	return chords
+}
+
+// A tutor checks the chords played against a line of target text.
+type tutor struct {
+	layout   Layout
+	chords   map[string]input.Chord
+	progress *TutorProgress
+
+	line    []rune
+	pos     int
+	started time.Time
+	errors  int
+	last    string
+}
+
+// chordFor returns the chord that types a character with the shift
+// modifier added for upper case letters.
+func (t *tutor) chordFor(c rune) (input.Chord, bool) {
+	key, exists := corpusKeys[unicode.ToLower(c)]
+	if !exists {
+		return 0, false
+	}
+
+	chord, isBound := t.chords[describeOutput(key)]
+	if unicode.IsUpper(c) {
+		chord |= MOD_SHIFT
+	}
+	return chord, isBound
+}
+
+// outputOf describes what a character is typed with, which any chord
+// that has the same output can be played for.
+func outputOf(c rune) string {
+	key := corpusKeys[unicode.ToLower(c)]
+	if unicode.IsUpper(c) {
+		key = applyModifiersTo(key, MOD_SHIFT)
+	}
+	return describeOutput(key)
+}
+
+func (t *tutor) start(line string) {
+	t.line = []rune(line)
+	t.pos = 0
+	t.started = time.Time{}
+	t.errors = 0
+	t.skipUnbound()
+}
+
+// skipUnbound moves past the characters that the layout can't type
+// so they don't block the rest of the line.
+func (t *tutor) skipUnbound() {
+	for !t.done() {
+		if _, isBound := t.chordFor(t.line[t.pos]); isBound {
+			return
+		}
+		t.pos++
+	}
+}
+
+func (t *tutor) done() bool {
+	return t.pos >= len(t.line)
+}
+
+// wpm is the words per minute of the line so far, counting five
+// characters as a word.
+func (t *tutor) wpm() float64 {
+	if t.started.IsZero() || t.pos == 0 {
+		return 0
+	}
+
+	minutes := time.Since(t.started).Minutes()
+	if minutes == 0 {
+		return 0
+	}
+	return float64(t.pos) / 5 / minutes
+}
+
+// Play checks a chord against the next character of the line.
+func (t *tutor) Play(chord input.Chord) {
+	if t.done() {
+		return
+	}
+
+	if t.started.IsZero() {
+		t.started = time.Now()
+	}
+
+	target := t.line[t.pos]
+	progress := t.progress.char(unicode.ToLower(target))
+	progress.Attempts++
+
+	key, isBound := t.layout.Lookup(chord)
+	if isBound && describeOutput(key) == outputOf(target) {
+		t.pos++
+		t.last = ""
+		t.skipUnbound()
+		return
+	}
+
+	progress.Errors++
+	t.errors++
+	if isBound {
+		t.last = fmt.Sprintf("chord %d is %s", chord, describeOutput(key))
+	} else {
+		t.last = fmt.Sprintf("chord %d is unbound", chord)
+	}
+}
+
+func (t *tutor) draw(w io.Writer) {
+	b := bytes.Buffer{}
+	b.WriteString(ansiClear)
+	b.WriteString("chordpad tutor\n\n  ")
+
+	for i, c := range t.line {
+		switch {
+		case i < t.pos:
+			b.WriteString(string(c))
+		case i == t.pos:
+			b.WriteString(ansiReverse + string(c) + ansiReset)
+		default:
+			b.WriteString(ansiBold + string(c) + ansiReset)
+		}
+	}
+	b.WriteString("\n\n")
+
+	// Characters without a chord are skipped so the next is bound
+	if !t.done() {
+		target := t.line[t.pos]
+		chord, _ := t.chordFor(target)
+		fmt.Fprintf(&b, "  next %q, chord %d\n\n", target, chord)
+
+		diagram := monitorScreen{model: input.Model{Keys: chord}}
+		left, right := diagram.pad(0), diagram.pad(4)
+		for i := range left {
+			fmt.Fprintf(&b, "  %s      %s\n", left[i], right[i])
+		}
+		b.WriteString("\n ")
+		for _, bit := range append(buttonBits, modBits...) {
+			if chord&bit.Chord != 0 {
+				b.WriteString(" " + diagram.label(bit.Chord, bit.Name))
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "\n  %.1f wpm, %d errors\n", t.wpm(), t.errors)
+	if t.last != "" {
+		fmt.Fprintf(&b, "  %s\n", t.last)
+	}
+
+	w.Write(b.Bytes())
+}
+
+// weakest returns the characters with the highest error rate.
+func (p *TutorProgress) weakest(n int) []string {
+	var chars []string
+	for c, progress := range p.Chars {
+		if progress.Errors > 0 {
+			chars = append(chars, c)
+		}
+	}
+	sort.Slice(chars, func(i, j int) bool {
+		ri, rj := p.Chars[chars[i]].ErrorRate(), p.Chars[chars[j]].ErrorRate()
+		if ri == rj {
+			return chars[i] < chars[j]
+		}
+		return ri > rj
+	})
+
+	if len(chars) > n {
+		chars = chars[:n]
+	}
+	return chars
+}
+
+func readLessons(path string) ([]string, error) {
+	if path == "" {
+		return tutorLessons, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// tutorCommand shows lines of text to type and checks the chords that
+// are played against them. No output is sent to a virtual keyboard.
+func tutorCommand(args []string) error {
+	flags := flag.NewFlagSet("tutor", flag.ExitOnError)
+	devicePath := flags.String("device", "", "evdev device to practice with, auto selected if empty")
+	replayPath := flags.String("replay", "", "play a recording instead of a device")
+	lessonPath := flags.String("lessons", "", "file of lines to practice, one per line")
+	progressPath := flags.String("progress", defaultDataPath("tutor.json"), "file the progress is saved in")
+	selectedLayout := layoutFlag(flags)
+	flags.Parse(args)
+
+	layout, err := selectedLayout()
+	if err != nil {
+		return err
+	}
+
+	lessons, err := readLessons(*lessonPath)
+	if err != nil {
+		return err
+	}
+
+	if len(lessons) == 0 {
+		return errors.New("there are no lessons to practice")
+	}
+
+	progress, err := LoadTutorProgress(*progressPath)
+	if err != nil {
+		return err
+	}
+	progress.Sessions++
+
+	events, err := openEventSource(*devicePath, *replayPath)
+	if err != nil {
+		return err
+	}
+
+	dev := newSteamController(events)
+	defer dev.Close()
+
+	t := tutor{layout: layout, chords: chordsFor(layout), progress: progress}
+
+	// A line without a character the layout can type is already done
+	var playable []string
+	for _, lesson := range lessons {
+		if t.start(lesson); !t.done() {
+			playable = append(playable, lesson)
+		}
+	}
+	if len(playable) == 0 {
+		return errors.New("the layout can't type any of the lessons")
+	}
+	lessons = playable
+
+	t.start(lessons[0])
+	t.draw(os.Stdout)
+
+	// Interrupting the tutor ends the events so the progress of the
+	// line being typed is saved
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	line := 0
+	source := input.Source{Device: dev}
+	for e := range source.Events(ctx) {
+		committed, isCommit := e.(input.ChordCommitted)
+		if !isCommit {
+			continue
+		}
+
+		t.Play(committed.Chord)
+		if t.done() {
+			progress.Lines++
+			progress.LastWPM = t.wpm()
+			if progress.LastWPM > progress.BestWPM {
+				progress.BestWPM = progress.LastWPM
+			}
+
+			if err := progress.Save(*progressPath); err != nil {
+				return err
+			}
+
+			line = (line + 1) % len(lessons)
+			t.start(lessons[line])
+		}
+		t.draw(os.Stdout)
+	}
+
+	if err := progress.Save(*progressPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n  best %.1f wpm, last %.1f wpm over %d lines in %d sessions\n",
+		progress.BestWPM, progress.LastWPM, progress.Lines, progress.Sessions)
+	for _, c := range progress.weakest(5) {
+		fmt.Printf("  %q misses %.0f%% of the time\n", c, 100*progress.Chars[c].ErrorRate())
+	}
+
+	if err := source.Err(); err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ghthor/chordpad/input"
+	"github.com/ghthor/uinput"
+)
+
+// Undo retracts the output of the last chord played. It only has an
+// effect when played by a Player, which keeps the history of output.
+type Undo struct{}
+
+// Retry retracts the output of the last chord played and plays the
+// chord one key away from it instead. Retrying again moves on to the
+// next chord one key away, for when a key was missed or pressed by
+// mistake. Like Undo it only has an effect when played by a Player.
+type Retry struct{}
+
+func (Undo) OutputTo(sink KeySink) error  { return nil }
+func (Retry) OutputTo(sink KeySink) error { return nil }
+
+func (Undo) String() string  { return "undo" }
+func (Retry) String() string { return "retry" }
+
+// historySize is how many chords can be undone one after another.
+const historySize = 32
+
+// A played is the output of a chord that can be undone.
+type played struct {
+	chord input.Chord
+	key   OutputEvent
+
+	// Times the key was output, more than once if it repeated.
+	count int
+
+	// Set if the key was played by Retry, the number of neighbours
+	// of the chord that have been tried.
+	retries int
+}
+
+// typedKeys are the keys that type a character, which is deleted
+// with a backspace.
+var typedKeys = map[int]bool{
+	uinput.KEY_SPACE:      true,
+	uinput.KEY_TAB:        true,
+	uinput.KEY_ENTER:      true,
+	uinput.KEY_MINUS:      true,
+	uinput.KEY_EQUAL:      true,
+	uinput.KEY_LEFTBRACE:  true,
+	uinput.KEY_RIGHTBRACE: true,
+	uinput.KEY_SEMICOLON:  true,
+	uinput.KEY_APOSTROPHE: true,
+	uinput.KEY_GRAVE:      true,
+	uinput.KEY_BACKSLASH:  true,
+	uinput.KEY_COMMA:      true,
+	uinput.KEY_DOT:        true,
+	uinput.KEY_SLASH:      true,
+}
+
+// oppositeKeys are the keys that undo each other.
+var oppositeKeys = map[int]int{
+	uinput.KEY_LEFT:  uinput.KEY_RIGHT,
+	uinput.KEY_RIGHT: uinput.KEY_LEFT,
+	uinput.KEY_UP:    uinput.KEY_DOWN,
+	uinput.KEY_DOWN:  uinput.KEY_UP,
+}
+
+// retraction returns the key that undoes the output of a key, if
+// there is one. A typed character is deleted with a backspace and
+// a cursor movement, even one that selects, is undone by moving back.
+func retraction(key OutputEvent) (OutputEvent, bool) {
+	switch key := key.(type) {
+	case Letter, Num:
+		return Func(FN_BACKSPACE), true
+
+	case Func:
+		if opposite, exists := oppositeKeys[int(key)]; exists {
+			return Func(opposite), true
+		}
+		if typedKeys[int(key)] {
+			return Func(FN_BACKSPACE), true
+		}
+
+	case ShiftPlus:
+		return retractShifted(key.OutputEvent)
+
+	case Wrap:
+		if key.mod == uinput.KEY_RIGHTSHIFT || key.mod == uinput.KEY_LEFTSHIFT {
+			return retractShifted(key.OutputEvent)
+		}
+
+	case TapHold:
+		return retraction(key.Tap)
+
+	case Text:
+		// Only the characters that have a key were typed
+		typed := 0
+		for _, c := range key {
+			if _, exists := textKeys[c]; exists && c != '\b' {
+				typed++
+			}
+		}
+		if typed > 0 {
+			return Text(strings.Repeat("\b", typed)), true
+		}
+
+	default:
+	}
+	return nil, false
+}
+
+func retractShifted(key OutputEvent) (OutputEvent, bool) {
+	undo, isUndoable := retraction(key)
+	if !isUndoable || undo == Func(FN_BACKSPACE) {
+		return undo, isUndoable
+	}
+	return ShiftPlus{undo}, true
+}
+
+// record adds the output of a chord to the history and returns its
+// entry.
+func (p *Player) record(chord input.Chord, key OutputEvent) *played {
+	entry := &played{chord: chord, key: key, count: 1}
+	p.history = append(p.history, entry)
+	if len(p.history) > historySize {
+		p.history = p.history[len(p.history)-historySize:]
+	}
+	return entry
+}
+
+// undo retracts the output of the last chord in the history and
+// returns what it played.
+func (p *Player) undo() (last played, isUndone bool, err error) {
+	if len(p.history) == 0 {
+		log.Println("nothing to undo")
+		return last, false, nil
+	}
+
+	// Output that can't be undone stays in the history
+	last = *p.history[len(p.history)-1]
+	undo, isUndoable := retraction(last.key)
+	if !isUndoable {
+		log.Printf("the output of chord %d can't be undone", last.chord)
+		return last, false, nil
+	}
+	p.history = p.history[:len(p.history)-1]
+
+	for i := 0; i < last.count; i++ {
+		if err := undo.OutputTo(p.Output); err != nil {
+			return last, false, err
+		}
+	}
+	return last, true, nil
+}
+
+// retry undoes the last chord and plays the next of its neighbours,
+// the bound chords that differ from it by a single key. Once every
+// neighbour has been tried the chord itself is played again.
+func (p *Player) retry() error {
+	last, isUndone, err := p.undo()
+	if !isUndone {
+		return err
+	}
+
+	var neighbours []input.Chord
+	for bit := input.Chord(1); bit < MOD_SHIFT; bit <<= 1 {
+		chord := last.chord ^ bit
+		if key, isBound := p.Keymap.Lookup(chord); isBound && chord != 0 && !isHistoryKey(key) {
+			neighbours = append(neighbours, chord)
+		}
+	}
+
+	chord := last.chord
+	if last.retries < len(neighbours) {
+		chord = neighbours[last.retries]
+	}
+
+	key, isBound := p.Keymap.Lookup(chord)
+	if !isBound || isHistoryKey(key) {
+		return nil
+	}
+	if dual, isDual := key.(TapHold); isDual {
+		key = dual.Tap
+	}
+
+	log.Printf("retrying chord %d as %d", last.chord, chord)
+	if err := key.OutputTo(p.Output); err != nil {
+		return err
+	}
+
+	p.record(last.chord, key).retries = (last.retries + 1) % (len(neighbours) + 1)
+	return nil
+}
+
+// isHistoryKey reports whether a key acts on the history of output
+// instead of producing any.
+func isHistoryKey(key OutputEvent) bool {
+	switch key.(type) {
+	case Undo, Retry:
+		return true
+	default:
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghthor/uinput"
+)
+
+func TestRetraction(t *testing.T) {
+	cases := []struct {
+		key        OutputEvent
+		want       OutputEvent
+		isUndoable bool
+	}{
+		{Letter(uinput.KEY_E), Func(FN_BACKSPACE), true},
+		{ShiftPlus{Letter(uinput.KEY_E)}, Func(FN_BACKSPACE), true},
+		{Func(uinput.KEY_LEFT), Func(uinput.KEY_RIGHT), true},
+		{ShiftPlus{Func(uinput.KEY_LEFT)}, ShiftPlus{Func(uinput.KEY_RIGHT)}, true},
+		{TapHold{Func(FN_SPACE), uinput.KEY_LEFTSHIFT}, Func(FN_BACKSPACE), true},
+		{Text("but"), Text("\b\b\b"), true},
+
+		// Only the characters that have a key are typed
+		{Text("büt"), Text("\b\b"), true},
+		{Text("ü"), nil, false},
+
+		{Func(uinput.KEY_ESC), nil, false},
+		{Undo{}, nil, false},
+	}
+
+	for _, c := range cases {
+		undo, isUndoable := retraction(c.key)
+		if isUndoable != c.isUndoable || !reflect.DeepEqual(undo, c.want) {
+			t.Errorf("%v: got %#v, %v, want %#v, %v", c.key, undo, isUndoable, c.want, c.isUndoable)
+		}
+	}
+}
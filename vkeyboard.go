@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	evdev "github.com/ghthor/golang-evdev"
+)
+
+// The uinput ioctls of linux/uinput.h that create a keyboard.
+const (
+	uiDevCreate  = 0x5501     // _IO('U', 1)
+	uiDevDestroy = 0x5502     // _IO('U', 2)
+	uiSetEvBit   = 0x40045564 // _IOW('U', 100, int)
+	uiSetKeyBit  = 0x40045565 // _IOW('U', 101, int)
+)
+
+// uinputUserDev is the struct uinput_user_dev that describes a new
+// uinput device.
+type uinputUserDev struct {
+	Name [80]byte
+	ID   struct {
+		Bustype, Vendor, Product, Version uint16
+	}
+	EffectsMax uint32
+	AbsMax     [64]int32
+	AbsMin     [64]int32
+	AbsFuzz    [64]int32
+	AbsFlat    [64]int32
+}
+
+// VirtualKeyboardSettle is how long a new virtual keyboard is given
+// to be found by the desktop. Key events written before it has
+// opened the device are lost.
+const VirtualKeyboardSettle = 2 * time.Second
+
+// A VirtualKeyboard is a uinput keyboard that can type the keys 1 to
+// 255. The key events of a frame are held until Sync, which writes
+// them and their SYN_REPORT at once, so an application reads the
+// frame as keys that changed together. The uinput package can't do
+// this as it writes a SYN_REPORT after every key event it sends.
+type VirtualKeyboard struct {
+	file  *os.File
+	frame bytes.Buffer
+}
+
+// NewVirtualKeyboard creates a virtual keyboard with the uinput
+// device file at path.
+func NewVirtualKeyboard(path, name string) (*VirtualKeyboard, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	vk := &VirtualKeyboard{file: file}
+	if err := vk.create(name); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	time.Sleep(VirtualKeyboardSettle)
+	return vk, nil
+}
+
+func (vk *VirtualKeyboard) ioctl(req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, vk.file.Fd(), req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (vk *VirtualKeyboard) create(name string) error {
+	if err := vk.ioctl(uiSetEvBit, evdev.EV_KEY); err != nil {
+		return err
+	}
+	for key := 1; key < 256; key++ {
+		if err := vk.ioctl(uiSetKeyBit, uintptr(key)); err != nil {
+			return err
+		}
+	}
+
+	dev := uinputUserDev{}
+	copy(dev.Name[:len(dev.Name)-1], name)
+	dev.ID.Bustype = evdev.BUS_USB
+	dev.ID.Vendor = 0x4711
+	dev.ID.Product = 0x0815
+	dev.ID.Version = 1
+	if err := binary.Write(vk.file, binary.LittleEndian, &dev); err != nil {
+		return err
+	}
+
+	return vk.ioctl(uiDevCreate, 0)
+}
+
+// write adds an event to the frame. The kernel sets the time of the
+// events written to a uinput device.
+func (vk *VirtualKeyboard) write(eventType, code uint16, value int32) {
+	e := evdev.InputEvent{Type: eventType, Code: code, Value: value}
+	binary.Write(&vk.frame, binary.LittleEndian, &e)
+}
+
+func (vk *VirtualKeyboard) key(key int, value int32) error {
+	if key < 1 || key > 255 {
+		return fmt.Errorf("key %d can't be typed by the virtual keyboard", key)
+	}
+
+	vk.write(evdev.EV_KEY, uint16(key), value)
+	return nil
+}
+
+func (vk *VirtualKeyboard) KeyPress(key int) error   { return vk.key(key, 1) }
+func (vk *VirtualKeyboard) KeyRelease(key int) error { return vk.key(key, 0) }
+
+// Sync writes the key events since the last Sync as a single frame
+// ended by a SYN_REPORT.
+func (vk *VirtualKeyboard) Sync() error {
+	if vk.frame.Len() == 0 {
+		return nil
+	}
+
+	vk.write(evdev.EV_SYN, evdev.SYN_REPORT, 0)
+	_, err := vk.file.Write(vk.frame.Bytes())
+	vk.frame.Reset()
+	return err
+}
+
+// Close destroys the virtual keyboard. Any key events that weren't
+// synced are dropped.
+func (vk *VirtualKeyboard) Close() error {
+	err := vk.ioctl(uiDevDestroy, 0)
+	return firstError(err, vk.file.Close())
+}
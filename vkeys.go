@@ -0,0 +1,74 @@
+package main
+
+import "github.com/ghthor/uinput"
+
+// VirtualKeys maps the names of Windows VirtualKeyCode values, as
+// used by the InputSimulator library in the Vive experiment, to
+// the OutputEvent that types the same key with uinput.
+var VirtualKeys = map[string]OutputEvent{
+	"BACK":   Func(FN_BACKSPACE),
+	"TAB":    Func(FN_TAB),
+	"RETURN": Func(FN_ENTER),
+	"ESCAPE": Func(FN_ESCAPE),
+	"SPACE":  Func(FN_SPACE),
+	"DELETE": Func(FN_DELETE),
+
+	"PRIOR": Func(uinput.KEY_PAGEUP),
+	"NEXT":  Func(uinput.KEY_PAGEDOWN),
+	"END":   Func(uinput.KEY_END),
+	"HOME":  Func(uinput.KEY_HOME),
+	"LEFT":  Func(uinput.KEY_LEFT),
+	"UP":    Func(uinput.KEY_UP),
+	"RIGHT": Func(uinput.KEY_RIGHT),
+	"DOWN":  Func(uinput.KEY_DOWN),
+
+	"VK_0": Num(uinput.KEY_0),
+	"VK_1": Num(uinput.KEY_1),
+	"VK_2": Num(uinput.KEY_2),
+	"VK_3": Num(uinput.KEY_3),
+	"VK_4": Num(uinput.KEY_4),
+	"VK_5": Num(uinput.KEY_5),
+	"VK_6": Num(uinput.KEY_6),
+	"VK_7": Num(uinput.KEY_7),
+	"VK_8": Num(uinput.KEY_8),
+	"VK_9": Num(uinput.KEY_9),
+
+	"VK_A": Letter(uinput.KEY_A),
+	"VK_B": Letter(uinput.KEY_B),
+	"VK_C": Letter(uinput.KEY_C),
+	"VK_D": Letter(uinput.KEY_D),
+	"VK_E": Letter(uinput.KEY_E),
+	"VK_F": Letter(uinput.KEY_F),
+	"VK_G": Letter(uinput.KEY_G),
+	"VK_H": Letter(uinput.KEY_H),
+	"VK_I": Letter(uinput.KEY_I),
+	"VK_J": Letter(uinput.KEY_J),
+	"VK_K": Letter(uinput.KEY_K),
+	"VK_L": Letter(uinput.KEY_L),
+	"VK_M": Letter(uinput.KEY_M),
+	"VK_N": Letter(uinput.KEY_N),
+	"VK_O": Letter(uinput.KEY_O),
+	"VK_P": Letter(uinput.KEY_P),
+	"VK_Q": Letter(uinput.KEY_Q),
+	"VK_R": Letter(uinput.KEY_R),
+	"VK_S": Letter(uinput.KEY_S),
+	"VK_T": Letter(uinput.KEY_T),
+	"VK_U": Letter(uinput.KEY_U),
+	"VK_V": Letter(uinput.KEY_V),
+	"VK_W": Letter(uinput.KEY_W),
+	"VK_X": Letter(uinput.KEY_X),
+	"VK_Y": Letter(uinput.KEY_Y),
+	"VK_Z": Letter(uinput.KEY_Z),
+
+	"OEM_1":      Letter(uinput.KEY_SEMICOLON),
+	"OEM_PLUS":   Letter(uinput.KEY_EQUAL),
+	"OEM_COMMA":  Letter(uinput.KEY_COMMA),
+	"OEM_MINUS":  Letter(uinput.KEY_MINUS),
+	"OEM_PERIOD": Letter(uinput.KEY_DOT),
+	"OEM_2":      Letter(uinput.KEY_SLASH),
+	"OEM_3":      Letter(uinput.KEY_GRAVE),
+	"OEM_4":      Letter(uinput.KEY_LEFTBRACE),
+	"OEM_5":      Letter(uinput.KEY_BACKSLASH),
+	"OEM_6":      Letter(uinput.KEY_RIGHTBRACE),
+	"OEM_7":      Letter(uinput.KEY_APOSTROPHE),
+}